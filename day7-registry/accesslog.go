@@ -0,0 +1,103 @@
+package geerpc
+
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"time"
+)
+
+//访问日志的设置，每个完成的请求输出一行
+type AccessLogOption struct {
+	Logger			Logger			//为空时使用服务器的日志
+	SampleRate		float64			//成功请求的抽样比例，不在 (0, 1) 之间时全部记录，慢请求不参与抽样
+	Slow			time.Duration	//大于 0 时只记录耗时不少于 Slow 的成功请求，它们总是记录
+	PrincipalKey	string			//元数据中调用方身份的键，为空时使用 DefaultPrincipalKey
+}
+
+//调用方通过 WithMetadata 附带身份时默认使用的键
+const DefaultPrincipalKey = "principal"
+
+//访问日志的字段名
+const (
+	FieldTime		= "time"
+	FieldReqSize	= "req_bytes"
+	FieldRespSize	= "resp_bytes"
+	FieldDuration	= "duration"
+	FieldCode		= "code"		//成功时为 ok，其余见 metrics.go 中的错误分类
+	FieldPrincipal	= "principal"
+)
+
+//开启访问日志，opt 为空时关闭，可以在服务期间修改。
+//失败的请求和慢请求总是记录，抽样和慢请求模式只过滤其余成功的请求。
+func (server *Server) SetAccessLog(opt *AccessLogOption) {
+	server.accessLogOpt.Store(opt)
+}
+
+func (server *Server) accessLog(req *request, elapsed time.Duration, code string, respSize int64) {
+	opt, _ := server.accessLogOpt.Load().(*AccessLogOption)
+	if opt == nil {
+		return
+	}
+	if code == "" {
+		slow := opt.Slow > 0 && elapsed >= opt.Slow
+		if opt.Slow > 0 && !slow {
+			return
+		}
+		if !slow && opt.SampleRate > 0 && opt.SampleRate < 1 && rand.Float64() >= opt.SampleRate {
+			return
+		}
+		code = "ok"
+	}
+	key := opt.PrincipalKey
+	if key == "" {
+		key = DefaultPrincipalKey
+	}
+	logger := opt.Logger
+	if logger == nil {
+		logger = server.log()
+	}
+	logger.Log(LevelInfo, "rpc access",
+		F(FieldTime, time.Now().Format(time.RFC3339Nano)),
+		F(FieldPeer, req.conn.peer),
+		F(FieldMethod, req.h.ServiceMethod),
+		F(FieldSeq, req.h.Seq),
+		F(FieldReqSize, req.size),
+		F(FieldRespSize, respSize),
+		F(FieldDuration, elapsed),
+		F(FieldCode, code),
+		F(FieldPrincipal, req.h.Metadata[key]),
+	)
+}
+
+//统计连接上每个请求和响应的字节数。
+//实现了 io.ByteReader，gob 不会再套一层缓冲，读到的字节数就是请求消耗的字节数。
+type sizeConn struct {
+	r		*bufio.Reader
+	info	*connInfo
+	io.ReadWriteCloser
+}
+
+func newSizeConn(conn io.ReadWriteCloser, info *connInfo) *sizeConn {
+	return &sizeConn{r: bufio.NewReader(conn), info: info, ReadWriteCloser: conn}
+}
+
+func (c *sizeConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.info.read += int64(n)
+	return n, err
+}
+
+func (c *sizeConn) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.info.read++
+	}
+	return b, err
+}
+
+func (c *sizeConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.info.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,87 @@
+package geerpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestServer_AccessLog(t *testing.T) {
+	var logger captureLogger
+	server := NewServer()
+	var s Slow
+	_ = server.Register(&s)
+	server.SetAccessLog(&AccessLogOption{Logger: &logger})
+	client := serveAndDial(t, server)
+	ctx := WithMetadata(context.Background(), map[string]string{DefaultPrincipalKey: "alice"})
+	var reply int
+	_assert(client.Call(ctx, "Slow.Sleep", 10, &reply) == nil, "failed to call Slow.Sleep")
+	_assert(client.Call(ctx, "Slow.Nothing", 10, &reply) != nil, "expect error for unknown method")
+
+	entries := waitEntries(&logger, 2)
+	_assert(len(entries) == 2, "expect 2 access log entries, got %d", len(entries))
+	ok, bad := entries[0].fields, entries[1].fields
+	_assert(ok[FieldMethod] == "Slow.Sleep" && ok[FieldCode] == "ok" && ok[FieldPrincipal] == "alice",
+		"unexpected entry %v", ok)
+	_assert(ok[FieldReqSize].(int64) > 0 && ok[FieldRespSize].(int64) > 0, "expect sizes, got %v", ok)
+	_assert(ok[FieldDuration].(time.Duration) >= time.Millisecond*10, "unexpected duration %v", ok[FieldDuration])
+	_assert(ok[FieldPeer] != "" && ok[FieldSeq] != nil, "expect peer and seq, got %v", ok)
+	_assert(bad[FieldMethod] == "Slow.Nothing" && bad[FieldCode] == codeNotFound, "unexpected entry %v", bad)
+
+	//服务期间修改设置：只记录慢请求，失败的请求总是记录
+	var slowLogger captureLogger
+	server.SetAccessLog(&AccessLogOption{Logger: &slowLogger, Slow: time.Millisecond * 50})
+	_ = client.Call(ctx, "Slow.Sleep", 1, &reply)
+	_ = client.Call(ctx, "Slow.Sleep", 60, &reply)
+	_ = client.Call(ctx, "Slow.Nothing", 1, &reply)
+	entries = waitEntries(&slowLogger, 2)
+	_assert(len(entries) == 2 && entries[0].fields[FieldCode] == "ok" && entries[1].fields[FieldCode] == codeNotFound,
+		"expect the slow and the failed request, got %v", entries)
+}
+
+func TestServer_AccessLogSampling(t *testing.T) {
+	var logger captureLogger
+	server := NewServer()
+	var s Slow
+	_ = server.Register(&s)
+	//几乎不抽样，慢请求和失败的请求仍然记录
+	server.SetAccessLog(&AccessLogOption{Logger: &logger, SampleRate: 1e-9, Slow: time.Millisecond * 30})
+	client := serveAndDial(t, server)
+	var reply int
+	for i := 0; i < 20; i++ {
+		_ = client.Call(context.Background(), "Slow.Sleep", 0, &reply)
+	}
+	_ = client.Call(context.Background(), "Slow.Sleep", 40, &reply)
+	_ = client.Call(context.Background(), "Slow.Nothing", 0, &reply)
+	entries := waitEntries(&logger, 2)
+	_assert(len(entries) == 2, "expect only the slow and the failed request, got %v", entries)
+	codes := map[interface{}]interface{}{}
+	for _, e := range entries {
+		codes[e.fields[FieldMethod]] = e.fields[FieldCode]
+	}
+	_assert(codes["Slow.Sleep"] == "ok" && codes["Slow.Nothing"] == codeNotFound, "unexpected entries %v", entries)
+
+	//只抽样时快的成功请求被丢掉
+	var sampled captureLogger
+	server.SetAccessLog(&AccessLogOption{Logger: &sampled, SampleRate: 1e-9})
+	for i := 0; i < 20; i++ {
+		_ = client.Call(context.Background(), "Slow.Sleep", 0, &reply)
+	}
+	_ = client.Call(context.Background(), "Slow.Nothing", 0, &reply)
+	entries = waitEntries(&sampled, 1)
+	_assert(len(entries) == 1 && entries[0].fields[FieldCode] == codeNotFound, "expect only the failed request, got %v", entries)
+}
+
+//访问日志在发送响应之后记录，等待记录到 n 条或者超时
+func waitEntries(l *captureLogger, n int) []captureEntry {
+	deadline := time.Now().Add(time.Second)
+	for {
+		entries := l.find(LevelInfo)
+		if len(entries) >= n || time.Now().After(deadline) {
+			//再等一会儿，多出来的记录也要能发现
+			time.Sleep(time.Millisecond * 10)
+			return l.find(LevelInfo)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,211 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+//批量请求的服务名，服务端内置，不在 Services 中列出
+const (
+	batchServiceName	= "geerpc"
+	batchMethodName		= "Batch"
+	BatchServiceMethod	= batchServiceName + "." + batchMethodName
+)
+
+//批量请求的默认限制，见 SetBatchLimits
+const (
+	DefaultBatchMaxItems	= 1000	//一个批量请求最多的项数
+	DefaultBatchConcurrency	= 16	//并发执行时同时执行的项数
+)
+
+//批量请求的请求体。每一项的参数依次用 gob 编码在 Args 中，
+//同一类型的描述只发送一次，和连接使用的编码方式无关。
+type BatchArgs struct {
+	Methods	[]string	//每一项的 Service.Method
+	Args	[]byte
+	Ordered	bool		//按顺序执行，否则并发执行
+}
+
+//批量请求的响应，Errors 和 Methods 一一对应，
+//成功的项的返回值依次用 gob 编码在 Replies 中
+type BatchReply struct {
+	Errors	[]string
+	Replies	[]byte
+}
+
+//批量调用中的一项
+type BatchCall struct {
+	ServiceMethod	string
+	Args			interface{}
+	Reply			interface{}
+	Error			error		//这一项的错误
+}
+
+//把多个调用打包成一个请求发送，每一项的结果写入对应的 Reply 和 Error。
+//ordered 为 true 时服务端按顺序执行，否则并发执行。
+//返回的错误表示整个批量请求失败，这时每一项的 Error 也会被设置。
+func (client *Client) Batch(ctx context.Context, calls []*BatchCall, ordered bool) error {
+	args := BatchArgs{Methods: make([]string, len(calls)), Ordered: ordered}
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	for i, call := range calls {
+		args.Methods[i] = call.ServiceMethod
+		if err := enc.Encode(call.Args); err != nil {
+			return failBatch(calls, fmt.Errorf("rpc client: encode args of %s: %v", call.ServiceMethod, err))
+		}
+	}
+	args.Args = buf.Bytes()
+	var reply BatchReply
+	if err := client.Call(ctx, BatchServiceMethod, args, &reply); err != nil {
+		return failBatch(calls, err)
+	}
+	if len(reply.Errors) != len(calls) {
+		return failBatch(calls, fmt.Errorf("rpc client: batch expects %d results, got %d", len(calls), len(reply.Errors)))
+	}
+	dec := gob.NewDecoder(bytes.NewReader(reply.Replies))
+	for i, call := range calls {
+		call.Error = nil
+		if reply.Errors[i] != "" {
+			call.Error = errors.New(reply.Errors[i])
+			continue
+		}
+		if err := dec.Decode(call.Reply); err != nil {
+			return failBatch(calls[i:], fmt.Errorf("rpc client: decode reply of %s: %v", call.ServiceMethod, err))
+		}
+	}
+	return nil
+}
+
+func failBatch(calls []*BatchCall, err error) error {
+	for _, call := range calls {
+		call.Error = err
+	}
+	return err
+}
+
+//设置批量请求的限制：超过 maxItems 项的请求被拒绝，
+//并发执行时最多同时执行 concurrency 项。小于等于 0 时使用默认值。
+func (server *Server) SetBatchLimits(maxItems, concurrency int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.batchMaxItems = maxItems
+	server.batchConcurrency = concurrency
+}
+
+func (server *Server) batchLimits() (maxItems, concurrency int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	maxItems, concurrency = server.batchMaxItems, server.batchConcurrency
+	if maxItems <= 0 {
+		maxItems = DefaultBatchMaxItems
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	return
+}
+
+//服务端内置的批量服务
+func (server *Server) newBatchService() *service {
+	m, err := newFuncMethod(batchMethodName, server.batch)
+	if err != nil {
+		panic(err)
+	}
+	return &service{name: batchServiceName, method: map[string]*methodType{batchMethodName: m}}
+}
+
+//批量请求中的一项
+type batchItem struct {
+	svc				*service
+	mtype			*methodType
+	argv, replyv	reflect.Value
+	err				error
+}
+
+func (server *Server) batch(ctx context.Context, args BatchArgs, reply *BatchReply) error {
+	maxItems, concurrency := server.batchLimits()
+	if len(args.Methods) > maxItems {
+		return fmt.Errorf("rpc server: batch has %d items, more than %d", len(args.Methods), maxItems)
+	}
+	//先按顺序解码所有参数，找不到的方法也要跳过它的参数
+	items := make([]batchItem, len(args.Methods))
+	dec := gob.NewDecoder(bytes.NewReader(args.Args))
+	for i, serviceMethod := range args.Methods {
+		item := &items[i]
+		item.svc, item.mtype, item.err = server.findService(serviceMethod)
+		if item.err == nil && item.svc.name == batchServiceName {
+			item.err = errors.New("rpc server: nested batch is not allowed")
+		}
+		if item.err != nil {
+			if err := dec.DecodeValue(reflect.Value{}); err != nil {
+				return fmt.Errorf("rpc server: decode batch args: %v", err)
+			}
+			continue
+		}
+		item.argv, item.replyv = item.mtype.newArgv(), item.mtype.newReplyv()
+		argvi := item.argv.Interface()
+		if item.argv.Type().Kind() != reflect.Ptr {
+			argvi = item.argv.Addr().Interface()
+		}
+		if err := dec.Decode(argvi); err != nil {
+			return fmt.Errorf("rpc server: decode batch args of %s: %v", serviceMethod, err)
+		}
+	}
+
+	run := func(item *batchItem) {
+		if item.err != nil {
+			return
+		}
+		//超时后不再执行剩下的项
+		if err := ctx.Err(); err != nil {
+			item.err = err
+			return
+		}
+		start := time.Now()
+		item.err = item.svc.call(ctx, item.mtype, item.argv, item.replyv)
+		item.mtype.record(time.Since(start), item.err != nil)
+		if pe, ok := item.err.(*panicError); ok {
+			server.log().Log(LevelError, pe.Error(), F(FieldMethod, item.svc.name+"."+item.mtype.method.Name), F("stack", string(pe.stack)))
+		}
+	}
+	if args.Ordered {
+		for i := range items {
+			run(&items[i])
+		}
+	} else {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, concurrency)
+		for i := range items {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(item *batchItem) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				run(item)
+			}(&items[i])
+		}
+		wg.Wait()
+	}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	reply.Errors = make([]string, len(items))
+	for i, item := range items {
+		if item.err == nil {
+			item.err = enc.Encode(item.replyv.Interface())
+		}
+		if item.err != nil {
+			reply.Errors[i] = item.err.Error()
+		}
+	}
+	reply.Replies = buf.Bytes()
+	return nil
+}
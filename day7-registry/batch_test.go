@@ -0,0 +1,88 @@
+package geerpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClient_Batch(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	var s Slow
+	var p Panicky
+	_ = server.Register(&foo)
+	_ = server.Register(&s)
+	_ = server.Register(&p)
+	client := serveAndDial(t, server)
+
+	var calls []*BatchCall
+	for i := 0; i < 100; i++ {
+		calls = append(calls, &BatchCall{ServiceMethod: "Foo.Sum", Args: Args{i, i}, Reply: new(int)})
+	}
+	calls = append(calls,
+		&BatchCall{ServiceMethod: "Foo.Nothing", Args: Args{1, 1}, Reply: new(int)},
+		&BatchCall{ServiceMethod: "Panicky.Boom", Args: 1, Reply: new(int)},
+		&BatchCall{ServiceMethod: BatchServiceMethod, Args: BatchArgs{}, Reply: new(BatchReply)},
+		&BatchCall{ServiceMethod: "Foo.Sum", Args: Args{1, 2}, Reply: new(int)},
+	)
+	_assert(client.Batch(context.Background(), calls, false) == nil, "failed to send batch")
+	for i := 0; i < 100; i++ {
+		_assert(calls[i].Error == nil && *calls[i].Reply.(*int) == 2*i, "unexpected result %d: %v", i, calls[i].Error)
+	}
+	_assert(calls[100].Error != nil && calls[101].Error != nil && calls[102].Error != nil, "expect per-item errors")
+	_assert(calls[103].Error == nil && *calls[103].Reply.(*int) == 3, "expect items after errors to succeed")
+
+	//并发执行的耗时接近最慢的一项，按顺序执行是所有项之和
+	sleeps := func() []*BatchCall {
+		var calls []*BatchCall
+		for i := 0; i < 5; i++ {
+			calls = append(calls, &BatchCall{ServiceMethod: "Slow.Sleep", Args: 50, Reply: new(int)})
+		}
+		return calls
+	}
+	start := time.Now()
+	_assert(client.Batch(context.Background(), sleeps(), false) == nil, "failed to send batch")
+	_assert(time.Since(start) < time.Millisecond*200, "expect items to run concurrently, took %s", time.Since(start))
+	start = time.Now()
+	_assert(client.Batch(context.Background(), sleeps(), true) == nil, "failed to send batch")
+	_assert(time.Since(start) >= time.Millisecond*250, "expect items to run in order, took %s", time.Since(start))
+
+	//整个批量请求失败时每一项都带有错误
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	calls = sleeps()
+	_assert(client.Batch(ctx, calls, true) != nil && calls[0].Error != nil, "expect the batch to time out")
+}
+
+func TestServer_BatchLimits(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	var s Slow
+	_ = server.Register(&foo)
+	_ = server.Register(&s)
+	server.SetBatchLimits(4, 2)
+	client := serveAndDial(t, server)
+
+	var calls []*BatchCall
+	for i := 0; i < 5; i++ {
+		calls = append(calls, &BatchCall{ServiceMethod: "Foo.Sum", Args: Args{i, i}, Reply: new(int)})
+	}
+	_assert(client.Batch(context.Background(), calls, false) != nil, "expect error for too many items")
+
+	//最多同时执行两项，四项至少需要两轮
+	calls = calls[:0]
+	for i := 0; i < 4; i++ {
+		calls = append(calls, &BatchCall{ServiceMethod: "Slow.Sleep", Args: 50, Reply: new(int)})
+	}
+	start := time.Now()
+	_assert(client.Batch(context.Background(), calls, false) == nil, "failed to send batch")
+	elapsed := time.Since(start)
+	_assert(elapsed >= time.Millisecond*100 && elapsed < time.Millisecond*200, "expect 2 rounds, took %s", elapsed)
+
+	//内置服务的名字不能被注册
+	_assert(server.RegisterName(batchServiceName, &foo) != nil, "expect error for the reserved name")
+	_assert(server.RegisterFunc(BatchServiceMethod, func(args Args, reply *int) error { return nil }) != nil,
+		"expect error for the reserved name")
+	_assert(server.Replace(batchServiceName, &foo) != nil, "expect error for the reserved name")
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"geerpc/codec"
 	"io"
-	"log"
 	"net"
 	"sync"
 	"time"
@@ -21,6 +20,7 @@ type Call struct {
 	ServiceMethod	string		//类型
 	Args			interface{}	//参数
 	Reply			interface{}	//返回
+	Metadata		map[string]string	//随请求头发送的元数据
 	Error			error		
 	Done			chan *Call	
 }
@@ -41,6 +41,7 @@ type Client struct {
 	pending		map[uint64]*Call	//未处理完的请求
 	closing		bool				//用户端关闭
 	shutdown	bool				//服务器关闭||断开连接
+	addr		string				//对端地址，用于追踪
 }
 //保证实现
 
@@ -153,6 +154,13 @@ func Dial(network, address string, opts ...*Option) (client *Client,err error) {
 	return NewClient(conn, opt)
 }
 
+//发起调用的接口，Client 和 xclient.XClient 都实现了它，geerpc-gen 生成的客户端只依赖它
+type Caller interface {
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}) error
+}
+
+var _ Caller = (*Client)(nil)
+
 //解析options
 func parseOptions(opts ...*Option) (*Option, error) {
 	if len(opts) == 0 || opts[0] == nil {
@@ -161,7 +169,9 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	if len(opts) != 1 {
 		return nil, errors.New("number of options is more than 1")
 	}
-	opt := opts[0]
+	//复制一份，同一个 Option 可能被多个连接同时使用
+	o := *opts[0]
+	opt := &o
 	opt.MagicNumber = DefaultOption.MagicNumber
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
@@ -174,16 +184,18 @@ func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
 		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
-		log.Println("rpc client error:", err)
+		loggerOr(opt.Logger).Log(LevelError, "rpc client: invalid codec type", F("codec", opt.CodecType))
 		return nil, err
 	}
 	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client: options error: ", err)
+		loggerOr(opt.Logger).Log(LevelWarn, "rpc client: options error", F(FieldPeer, conn.RemoteAddr()), F(FieldError, err))
 		_ = conn.Close()
 		return nil, err
 	}
 	//f函数新建了一个codec,这个codec包含了连接
-	return newClientCodec(f(conn), opt), nil
+	client := newClientCodec(f(conn), opt)
+	client.addr = conn.RemoteAddr().String()
+	return client, nil
 }
 
 //设置其他参数，开始监听回复
@@ -204,8 +216,22 @@ func newClientCodec(cc codec.Codec, opt *Option) *Client {
 //Done是在pending中的一个seq对应的call调用done()时，会把自己送入自己的Done chan中
 //，然后再这里的.Done chan 中阻塞等待到recieve call回归，发出信号.
 //调用时一般会传引用类型的reply，到时候断言一下就行。
-func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+//ctx 中用 WithMetadata 设置的元数据会随请求发送。
+func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) (err error) {
+	ctx, span := client.startSpan(ctx, serviceMethod)
+	call := &Call{
+		ServiceMethod:	serviceMethod,
+		Args:			args,
+		Reply:			reply,
+		Metadata:		MetadataFromContext(ctx),
+		Done:			make(chan *Call, 1),
+	}
+	client.send(call)
+	if span != nil {
+		defer func() {
+			endSpan(span, call.Seq, err)
+		}()
+	}
 //context提供从父routing停止程序的方法。
 	select {
 	case <-ctx.Done():
@@ -221,7 +247,7 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 	if done == nil {
 		done = make(chan *Call, 10)
 	}	else if cap(done) == 0 {
-		log.Panic("rpc client: done channel is unbuffered")
+		panic("rpc client: done channel is unbuffered")
 	}
 	call := &Call{
 		ServiceMethod:	serviceMethod,
@@ -248,6 +274,7 @@ func (client *Client) send(call *Call) {
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.Metadata = call.Metadata
 
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
 		call := client.removeCall(seq)
@@ -274,11 +301,17 @@ type clientResult struct {
 type newClientFunc func(conn net.Conn, opt *Option) (client *Client, err error)
 
 func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
+	return dialContext(context.Background(), f, network, address, opts...)
+}
+
+//同 dialTimeout，ctx 取消时放弃建立连接
+func dialContext(ctx context.Context, f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
 	opt, err := parseOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	d := net.Dialer{Timeout: opt.ConnectTimeout}
+	conn, err := d.DialContext(ctx, network, address)
 	if err != nil {
 		return nil, err
 	}
@@ -288,18 +321,21 @@ func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (cli
 		}
 	}()
 
-	ch := make(chan clientResult)
+	//有缓冲，超时返回后协程不会阻塞
+	ch := make(chan clientResult, 1)
 	go func() {
 		client, err := f(conn, opt)
 		ch <- clientResult{client: client, err: err}
 	}()
 	
-	if opt.ConnectTimeout == 0 { 
-		result := <- ch
-		return result.client, result.err
-	}	
+	var timeout <-chan time.Time
+	if opt.ConnectTimeout > 0 {
+		timeout = time.After(opt.ConnectTimeout)
+	}
 	select {
-	case <-time.After(opt.ConnectTimeout):
+	case <-ctx.Done():
+		return nil, errors.New("rpc client: connect canceled: " + ctx.Err().Error())
+	case <-timeout:
 		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
 	case result := <-ch:
 		return result.client, result.err	
@@ -338,4 +374,19 @@ func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	default:
 		return Dial(protocol, addr, opts...)
 	}
+}
+
+//同 XDial，ctx 取消或者超过 ConnectTimeout 时放弃建立连接
+func XDialContext(ctx context.Context, rpcAddr string, opts ...*Option) (*Client, error) {
+	parts := strings.Split(rpcAddr, "@")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
+	}
+	protocol, addr := parts[0], parts[1]
+	switch protocol {
+	case "http":
+		return dialContext(ctx, NewHTTPClient, "tcp", addr, opts...)
+	default:
+		return dialContext(ctx, NewClient, protocol, addr, opts...)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,263 @@
+//geerpc-gen 根据服务类型生成带类型的客户端，用法：
+//
+//	//go:generate go run geerpc/cmd/geerpc-gen -type Foo
+//
+//对 Foo 的每个符合注册条件的方法 Sum(args Args, reply *int) error，
+//生成 FooClient.Sum(ctx, args Args) (int, error)，
+//通过 geerpc.Caller 调用，*geerpc.Client 和 *xclient.XClient 都可以使用。
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	typeNames	= flag.String("type", "", "逗号分隔的服务类型名，必填")
+	output		= flag.String("output", "", "输出文件，默认为 <第一个类型名小写>_geerpc.go")
+	geerpcPath	= flag.String("geerpc", "geerpc", "geerpc 包的导入路径")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("geerpc-gen: ")
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	types := strings.Split(*typeNames, ",")
+	src, err := generate(dir, types, *geerpcPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	name := *output
+	if name == "" {
+		name = strings.ToLower(types[0]) + "_geerpc.go"
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//服务的一个方法
+type method struct {
+	name		string
+	argType		string
+	replyType	string		//去掉指针后的类型
+}
+
+//解析 dir 中的包，生成 types 的客户端代码
+func generate(dir string, types []string, geerpcPath string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && !strings.HasSuffix(fi.Name(), "_geerpc.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expect one package in %s, found %d", dir, len(pkgs))
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+
+	imports := map[string]string{"context": "", geerpcPath: ""}
+	var body bytes.Buffer
+	for _, typ := range types {
+		typ = strings.TrimSpace(typ)
+		if !ast.IsExported(typ) {
+			return nil, fmt.Errorf("%s is not a valid service name", typ)
+		}
+		methods, err := collectMethods(fset, pkg, typ, imports)
+		if err != nil {
+			return nil, err
+		}
+		writeClient(&body, typ, methods)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by geerpc-gen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg.Name)
+	paths := make([]string, 0, len(imports))
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&buf, "\t%s %s\n", imports[path], strconv.Quote(path))
+	}
+	buf.WriteString(")\n")
+	buf.Write(body.Bytes())
+	return format.Source(buf.Bytes())
+}
+
+//按 service.registerMethods 的规则找出 typ 的方法：两个参数、返回 error，
+//参数类型导出或者是内置类型。reply 必须是指针，否则服务端无法写回结果。
+func collectMethods(fset *token.FileSet, pkg *ast.Package, typ string, imports map[string]string) ([]method, error) {
+	found := false
+	var methods []method
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					if ts, ok := spec.(*ast.TypeSpec); ok && ts.Name.Name == typ {
+						found = true
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Recv == nil || receiverName(d.Recv.List[0].Type) != typ || !d.Name.IsExported() {
+					continue
+				}
+				ft := d.Type
+				if countFields(ft.Params) != 2 || countFields(ft.Results) != 1 {
+					continue
+				}
+				if id, ok := ft.Results.List[0].Type.(*ast.Ident); !ok || id.Name != "error" {
+					continue
+				}
+				argExpr, replyExpr := paramType(ft.Params, 0), paramType(ft.Params, 1)
+				star, ok := replyExpr.(*ast.StarExpr)
+				if !ok {
+					log.Printf("skip %s.%s: reply is not a pointer", typ, d.Name.Name)
+					continue
+				}
+				if !exportedOrBuiltin(argExpr) || !exportedOrBuiltin(replyExpr) {
+					continue
+				}
+				addImports(file, argExpr, imports)
+				addImports(file, star.X, imports)
+				methods = append(methods, method{
+					name:		d.Name.Name,
+					argType:	exprString(fset, argExpr),
+					replyType:	exprString(fset, star.X),
+				})
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("type %s not found", typ)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].name < methods[j].name })
+	return methods, nil
+}
+
+func writeClient(buf *bytes.Buffer, typ string, methods []method) {
+	fmt.Fprintf(buf, "\n// %sClient 是 %s 服务的客户端\n", typ, typ)
+	fmt.Fprintf(buf, "type %sClient struct {\n\tc geerpc.Caller\n}\n\n", typ)
+	fmt.Fprintf(buf, "func New%sClient(c geerpc.Caller) *%sClient {\n\treturn &%sClient{c: c}\n}\n", typ, typ, typ)
+	for _, m := range methods {
+		fmt.Fprintf(buf, "\nfunc (c *%sClient) %s(ctx context.Context, args %s) (%s, error) {\n", typ, m.name, m.argType, m.replyType)
+		fmt.Fprintf(buf, "\tvar reply %s\n", m.replyType)
+		fmt.Fprintf(buf, "\terr := c.c.Call(ctx, %s, args, &reply)\n", strconv.Quote(typ+"."+m.name))
+		buf.WriteString("\treturn reply, err\n}\n")
+	}
+}
+
+//接收器的类型名，T 和 *T 都算
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if id, ok := expr.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+//参数个数，a, b int 算两个
+func countFields(fl *ast.FieldList) int {
+	if fl == nil {
+		return 0
+	}
+	n := 0
+	for _, f := range fl.List {
+		if len(f.Names) == 0 {
+			n++
+		} else {
+			n += len(f.Names)
+		}
+	}
+	return n
+}
+
+//第 i 个参数的类型
+func paramType(fl *ast.FieldList, i int) ast.Expr {
+	for _, f := range fl.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		if i < n {
+			return f.Type
+		}
+		i -= n
+	}
+	return nil
+}
+
+var builtinTypes = map[string]bool{
+	"bool": true, "byte": true, "complex64": true, "complex128": true, "error": true,
+	"float32": true, "float64": true, "int": true, "int8": true, "int16": true,
+	"int32": true, "int64": true, "rune": true, "string": true, "uint": true,
+	"uint8": true, "uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+}
+
+//同 isExportedOrBuiltinType：有名字的类型要导出，指针、切片等没有名字的类型都可以
+func exportedOrBuiltin(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.IsExported() || builtinTypes[e.Name]
+	case *ast.SelectorExpr:
+		return e.Sel.IsExported()
+	}
+	return true
+}
+
+//类型中用到的其他包，按 file 的 import 找到导入路径，保留别名
+func addImports(file *ast.File, expr ast.Expr, imports map[string]string) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if id, ok := sel.X.(*ast.Ident); ok {
+			for _, imp := range file.Imports {
+				path, _ := strconv.Unquote(imp.Path.Value)
+				name, alias := path[strings.LastIndex(path, "/")+1:], ""
+				if imp.Name != nil {
+					name, alias = imp.Name.Name, imp.Name.Name
+				}
+				if name == id.Name {
+					imports[path] = alias
+				}
+			}
+		}
+		return false
+	})
+}
+
+func exprString(fset *token.FileSet, expr ast.Expr) string {
+	var buf bytes.Buffer
+	_ = printer.Fprint(&buf, fset, expr)
+	return buf.String()
+}
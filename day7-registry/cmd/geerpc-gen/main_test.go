@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSource = `package svc
+
+import (
+	"context"
+	t "time"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type reply int
+
+type Foo int
+
+func (f Foo) Sum(args Args, reply *int) error { return nil }
+
+func (f *Foo) Wait(d t.Duration, reply *[]string) error { return nil }
+
+func (f Foo) NoPointer(args Args, reply int) error { return nil }
+
+func (f Foo) Unexported(args reply, r *int) error { return nil }
+
+func (f Foo) Ctx(ctx context.Context, args Args) error { return nil }
+
+func (f Foo) private(args Args, reply *int) error { return nil }
+`
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "svc.go"), []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(dir, []string{"Foo"}, "geerpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+	for _, want := range []string{
+		"package svc",
+		`t "time"`,
+		"func (c *FooClient) Sum(ctx context.Context, args Args) (int, error)",
+		`c.c.Call(ctx, "Foo.Sum", args, &reply)`,
+		"func (c *FooClient) Wait(ctx context.Context, args t.Duration) ([]string, error)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expect %q in generated code:\n%s", want, out)
+		}
+	}
+	for _, skip := range []string{"NoPointer", "Unexported", "Ctx(", "private"} {
+		if strings.Contains(out, skip) {
+			t.Fatalf("expect %s to be skipped:\n%s", skip, out)
+		}
+	}
+	if _, err := generate(dir, []string{"Bar"}, "geerpc"); err == nil {
+		t.Fatal("expect an error for a missing type")
+	}
+}
@@ -0,0 +1,326 @@
+//geerpc 是调试用的命令行客户端：
+//
+//	geerpc -addr tcp@127.0.0.1:9999 call Foo.Sum '{"Num1":1,"Num2":2}' -reply 0
+//	geerpc -registry http://127.0.0.1:9999/_geerpc_/registry list
+//	geerpc -addr tcp@127.0.0.1:9999 describe Foo
+//	geerpc -registry http://127.0.0.1:9999/_geerpc_/registry -broadcast call Foo.Sum '{"Num1":1}'
+//
+//服务器开启了 Reflection 服务时，参数和回复按方法的类型解析。否则参数按JSON的值推断出
+//gob 可以编码的类型：对象对应结构体（字段按名字匹配，首字母自动大写），整数对应 int64，
+//小数对应 float64，数组对应切片；回复的类型由 -reply 给出的JSON样例推断，没有时只检查调用是否成功。
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"geerpc"
+	"geerpc/registry"
+	"geerpc/xclient"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("geerpc: ")
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//-md k=v，可以重复
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string {
+	return fmt.Sprint(map[string]string(m))
+}
+
+func (m metadataFlag) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid metadata %q, expect key=value", v)
+	}
+	m[v[:i]] = v[i+1:]
+	return nil
+}
+
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("geerpc", flag.ContinueOnError)
+	addr := fs.String("addr", "", "服务器地址，格式同 XDial，比如 tcp@127.0.0.1:9999，多个地址用逗号分隔")
+	registryAddr := fs.String("registry", "", "注册中心地址，多个地址用逗号分隔")
+	timeout := fs.Duration("timeout", time.Second*5, "调用超时时间，0 表示不限制")
+	broadcast := fs.Bool("broadcast", false, "调用所有服务器")
+	replySample := fs.String("reply", "", "回复的JSON样例，用于推断回复的类型")
+	md := metadataFlag{}
+	fs.Var(md, "md", "随请求发送的元数据 key=value，可以重复")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: geerpc [flags] list | describe Service | call Service.Method [json-args]")
+		fs.PrintDefaults()
+	}
+	//允许把选项写在子命令和参数后面
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if args = fs.Args(); len(args) == 0 {
+			break
+		}
+		rest, args = append(rest, args[0]), args[1:]
+	}
+	if len(rest) == 0 {
+		fs.Usage()
+		return errors.New("missing command")
+	}
+	if *addr == "" && *registryAddr == "" {
+		return errors.New("either -addr or -registry is required")
+	}
+
+	var d xclient.Discovery
+	if *registryAddr != "" {
+		d = xclient.NewGeeRegistryDiscovery(*registryAddr, 0)
+	} else {
+		d = xclient.NewMultiServerDiscovery(registry.SplitRegistries(*addr))
+	}
+	switch rest[0] {
+	case "list":
+		//注册中心有完整的服务器列表，否则问服务器的 Reflection 服务
+		if *registryAddr != "" {
+			return list(*registryAddr, stdout)
+		}
+		return listReflection(d, *timeout, stdout)
+	case "describe":
+		if len(rest) != 2 {
+			return errors.New("usage: describe Service")
+		}
+		return describe(d, rest[1], *timeout, stdout)
+	case "call":
+		if len(rest) < 2 || len(rest) > 3 {
+			return errors.New("usage: call Service.Method [json-args]")
+		}
+		argsJSON := "{}"
+		if len(rest) == 3 {
+			argsJSON = rest[2]
+		}
+		return call(d, rest[1], argsJSON, *replySample, *timeout, md, *broadcast, stdout)
+	}
+	return fmt.Errorf("unknown command %q", rest[0])
+}
+
+//列出注册中心中的服务器和它们的服务
+func list(registryAddr string, stdout io.Writer) error {
+	var err error
+	for _, addr := range registry.SplitRegistries(registryAddr) {
+		var servers registry.ServerList
+		if servers, err = fetchServers(addr); err == nil {
+			for _, s := range servers.Servers {
+				line := s.Addr
+				if s.Version != "" {
+					line += " version=" + s.Version
+				}
+				if s.Zone != "" {
+					line += " zone=" + s.Zone
+				}
+				fmt.Fprintf(stdout, "%s\n", line)
+				for _, svc := range s.Services {
+					fmt.Fprintf(stdout, "\t%s\n", svc)
+				}
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+func fetchServers(addr string) (registry.ServerList, error) {
+	var list registry.ServerList
+	req, err := http.NewRequest("GET", addr, nil)
+	if err != nil {
+		return list, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := (&http.Client{Timeout: time.Second * 10}).Do(req)
+	if err != nil {
+		return list, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return list, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return list, errors.New("registry does not support JSON, upgrade it to list services")
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	return list, err
+}
+
+func call(d xclient.Discovery, serviceMethod, argsJSON, replySample string, timeout time.Duration, md map[string]string, broadcast bool, stdout io.Writer) error {
+	ctx, cancel := withTimeout(timeout)
+	defer cancel()
+	xc := newXClient(d)
+	defer func() {
+		_ = xc.Close()
+	}()
+
+	//服务器开启了 Reflection 时按方法的类型解析参数和回复，否则按JSON的值推断
+	var args, reply interface{}
+	m, err := lookupMethod(ctx, xc, serviceMethod)
+	if err == nil {
+		if args, reply, err = typedArgs(m, argsJSON); err != nil {
+			return err
+		}
+	} else {
+		v, err := parseJSON(argsJSON)
+		if err != nil {
+			return fmt.Errorf("invalid args: %v", err)
+		}
+		args = v.Interface()
+	}
+	if replySample != "" {
+		sample, err := parseJSON(replySample)
+		if err != nil {
+			return fmt.Errorf("invalid reply sample: %v", err)
+		}
+		reply = reflect.New(sample.Type()).Interface()
+	}
+
+	if len(md) > 0 {
+		ctx = geerpc.WithMetadata(ctx, md)
+	}
+	if broadcast {
+		err = xc.Broadcast(ctx, serviceMethod, args, reply)
+	} else {
+		err = xc.Call(ctx, serviceMethod, args, reply)
+	}
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		fmt.Fprintln(stdout, "ok")
+		return nil
+	}
+	return printJSON(stdout, reply)
+}
+
+func printJSON(stdout io.Writer, v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s\n", out)
+	return nil
+}
+
+func newXClient(d xclient.Discovery) *xclient.XClient {
+	return xclient.NewXClient(d, xclient.RandomSelect, &geerpc.Option{ConnectTimeout: time.Second * 10})
+}
+
+//timeout 为 0 时不限制
+func withTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+//解析JSON并转换成 gob 可以编码的值
+func parseJSON(s string) (reflect.Value, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return reflect.Value{}, err
+	}
+	return toValue(v)
+}
+
+func toValue(v interface{}) (reflect.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return reflect.Value{}, errors.New("null is not supported")
+	case bool, string:
+		return reflect.ValueOf(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return reflect.ValueOf(i), nil
+		}
+		f, err := v.Float64()
+		return reflect.ValueOf(f), err
+	case []interface{}:
+		//空数组当作字符串切片，元素的类型要一致
+		if len(v) == 0 {
+			return reflect.ValueOf([]string{}), nil
+		}
+		elems := make([]reflect.Value, len(v))
+		for i, e := range v {
+			ev, err := toValue(e)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			if i > 0 && ev.Type() != elems[0].Type() {
+				return reflect.Value{}, fmt.Errorf("array elements have different types: %s and %s", elems[0].Type(), ev.Type())
+			}
+			elems[i] = ev
+		}
+		slice := reflect.MakeSlice(reflect.SliceOf(elems[0].Type()), 0, len(v))
+		return reflect.Append(slice, elems...), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		seen := make(map[string]bool, len(keys))
+		fields := make([]reflect.StructField, 0, len(keys))
+		values := make([]reflect.Value, 0, len(keys))
+		for _, k := range keys {
+			name := exportName(k)
+			if name == "" || seen[name] {
+				return reflect.Value{}, fmt.Errorf("invalid or duplicate field name %q", k)
+			}
+			seen[name] = true
+			fv, err := toValue(v[k])
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("field %s: %v", k, err)
+			}
+			fields = append(fields, reflect.StructField{Name: name, Type: fv.Type(), Tag: reflect.StructTag(fmt.Sprintf(`json:%q`, k))})
+			values = append(values, fv)
+		}
+		sv := reflect.New(reflect.StructOf(fields)).Elem()
+		for i, fv := range values {
+			sv.Field(i).Set(fv)
+		}
+		return sv, nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported JSON value %v", v)
+}
+
+//结构体字段要导出才能被 gob 编码
+func exportName(k string) string {
+	if k == "" {
+		return ""
+	}
+	r := []rune(k)
+	r[0] = unicode.ToUpper(r[0])
+	for i, c := range r {
+		if !unicode.IsLetter(c) && c != '_' && (i == 0 || !unicode.IsDigit(c)) {
+			return ""
+		}
+	}
+	if !unicode.IsUpper(r[0]) {
+		return ""
+	}
+	return string(r)
+}
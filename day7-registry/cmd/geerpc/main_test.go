@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"geerpc"
+	"geerpc/registry"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Result struct {
+	Sum   int
+	Names []string
+}
+
+type Foo int
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f Foo) Describe(args Args, reply *Result) error {
+	*reply = Result{Sum: args.Num1 + args.Num2, Names: []string{"a", "b"}}
+	return nil
+}
+
+type Scale struct {
+	Factor float64
+	Values []int
+}
+
+type Bar int
+
+func (b Bar) Scale(args Scale, reply *[]float64) error {
+	for _, v := range args.Values {
+		*reply = append(*reply, float64(v)*args.Factor)
+	}
+	return nil
+}
+
+func startServer(t *testing.T, reflection bool) (*geerpc.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := geerpc.NewServer()
+	var foo Foo
+	var bar Bar
+	_ = server.Register(&foo)
+	_ = server.Register(&bar)
+	if reflection {
+		_ = server.EnableReflection()
+	}
+	go server.Accept(l)
+	t.Cleanup(func() { _ = l.Close() })
+	return server, "tcp@" + l.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	server, addr := startServer(t, false)
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	registry.HeartbeatServer(ts.URL, server, registry.ServerItem{Addr: addr, Version: "v1"}, time.Hour)
+
+	for _, c := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"int reply", []string{"-addr", addr, "call", "Foo.Sum", `{"num1":1,"Num2":2}`, "-reply", "0"}, "3"},
+		{"struct reply", []string{"-addr", addr, "-md", "user=alice", "call", "Foo.Describe", `{"Num1":1,"Num2":2}`, "-reply", `{"Sum":0,"Names":["x"]}`}, `"Sum": 3`},
+		{"no reply", []string{"-addr", addr, "call", "Foo.Sum", `{"Num1":1}`}, "ok"},
+		{"broadcast", []string{"-registry", ts.URL, "-broadcast", "call", "Foo.Sum", `{"Num1":1}`, "-reply", "0"}, "1"},
+		{"list", []string{"-registry", ts.URL, "list"}, "\tFoo"},
+		{"spaced addr list", []string{"-addr", " " + addr + ", ", "call", "Foo.Sum", `{"Num1":1}`, "-reply", "0"}, "1"},
+		{"spaced registry list", []string{"-registry", " " + ts.URL + ", ", "list"}, "\tFoo"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(c.args, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), c.want) {
+				t.Fatalf("expect %q in output, got %q", c.want, out.String())
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{"-addr", addr, "call", "Foo.Sum", `{"Num1":1.5}`, "-reply", "0"}, &out); err == nil {
+		t.Fatal("expect an error for a float argument")
+	}
+	if err := run([]string{"-addr", addr, "call", "Foo.Sum", `{"Num1":1,"num1":2}`}, &out); err == nil {
+		t.Fatal("expect an error for duplicate fields")
+	}
+}
+
+func TestRun_Reflection(t *testing.T) {
+	_, addr := startServer(t, true)
+	for _, c := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"list", []string{"-addr", addr, "list"}, "\tSum(Args) int"},
+		{"describe", []string{"-addr", addr, "describe", "Bar"}, `"Factor"`},
+		{"typed args", []string{"-addr", addr, "call", "Bar.Scale", `{"Factor":1.5,"Values":[2,4]}`}, "3,\n  6"},
+		{"typed reply", []string{"-addr", addr, "call", "Foo.Describe", `{"Num1":1,"Num2":2}`}, `"Names": [`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(c.args, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), c.want) {
+				t.Fatalf("expect %q in output, got %q", c.want, out.String())
+			}
+		})
+	}
+}
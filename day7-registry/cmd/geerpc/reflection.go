@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geerpc"
+	"geerpc/xclient"
+	"io"
+	"context"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//通过 Reflection 服务列出服务和方法
+func listReflection(d xclient.Discovery, timeout time.Duration, stdout io.Writer) error {
+	ctx, cancel := withTimeout(timeout)
+	defer cancel()
+	xc := newXClient(d)
+	defer func() {
+		_ = xc.Close()
+	}()
+	var descs []geerpc.ServiceDesc
+	if err := xc.Call(ctx, "Reflection.Describe", "", &descs); err != nil {
+		return fmt.Errorf("list needs -registry or a server with reflection enabled: %v", err)
+	}
+	for _, desc := range descs {
+		fmt.Fprintf(stdout, "%s\n", desc.Name)
+		for _, m := range desc.Methods {
+			fmt.Fprintf(stdout, "\t%s(%s) %s\n", m.Name, typeString(m.ArgType), typeString(m.ReplyType.Elem))
+		}
+	}
+	return nil
+}
+
+//打印服务的完整描述
+func describe(d xclient.Discovery, service string, timeout time.Duration, stdout io.Writer) error {
+	ctx, cancel := withTimeout(timeout)
+	defer cancel()
+	xc := newXClient(d)
+	defer func() {
+		_ = xc.Close()
+	}()
+	var desc geerpc.ServiceDesc
+	if err := xc.Call(ctx, "Reflection.DescribeService", service, &desc); err != nil {
+		return err
+	}
+	return printJSON(stdout, desc)
+}
+
+//查询方法的描述，服务器没有开启 Reflection 时返回错误
+func lookupMethod(ctx context.Context, xc *xclient.XClient, serviceMethod string) (*geerpc.MethodDesc, error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, errors.New("service/method request ill-formed: " + serviceMethod)
+	}
+	var desc geerpc.ServiceDesc
+	if err := xc.Call(ctx, "Reflection.DescribeService", serviceMethod[:dot], &desc); err != nil {
+		return nil, err
+	}
+	for i := range desc.Methods {
+		if desc.Methods[i].Name == serviceMethod[dot+1:] {
+			return &desc.Methods[i], nil
+		}
+	}
+	return nil, errors.New("can't find method " + serviceMethod)
+}
+
+//按方法的类型解析参数，并新建回复；类型无法构造时（比如递归类型）返回错误
+func typedArgs(m *geerpc.MethodDesc, argsJSON string) (args, reply interface{}, err error) {
+	argType, err := typeFromDesc(m.ArgType)
+	if err != nil {
+		return nil, nil, err
+	}
+	replyType, err := typeFromDesc(m.ReplyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	argv := reflect.New(argType)
+	if err := json.Unmarshal([]byte(argsJSON), argv.Interface()); err != nil {
+		return nil, nil, fmt.Errorf("invalid args for %s: %v", typeString(m.ArgType), err)
+	}
+	return argv.Elem().Interface(), reflect.New(replyType.Elem()).Interface(), nil
+}
+
+var basicTypes = map[string]reflect.Type{
+	"bool":		reflect.TypeOf(false),
+	"int":		reflect.TypeOf(int(0)),
+	"int8":		reflect.TypeOf(int8(0)),
+	"int16":	reflect.TypeOf(int16(0)),
+	"int32":	reflect.TypeOf(int32(0)),
+	"int64":	reflect.TypeOf(int64(0)),
+	"uint":		reflect.TypeOf(uint(0)),
+	"uint8":	reflect.TypeOf(uint8(0)),
+	"uint16":	reflect.TypeOf(uint16(0)),
+	"uint32":	reflect.TypeOf(uint32(0)),
+	"uint64":	reflect.TypeOf(uint64(0)),
+	"float32":	reflect.TypeOf(float32(0)),
+	"float64":	reflect.TypeOf(float64(0)),
+	"string":	reflect.TypeOf(""),
+}
+
+//按描述构造结构相同的类型，gob 按结构而不是类型名匹配
+func typeFromDesc(d *geerpc.TypeDesc) (reflect.Type, error) {
+	if d == nil || d.Recursive {
+		return nil, errors.New("unsupported recursive type")
+	}
+	if t, ok := basicTypes[d.Kind]; ok {
+		return t, nil
+	}
+	switch d.Kind {
+	case "ptr", "slice", "array":
+		elem, err := typeFromDesc(d.Elem)
+		if err != nil {
+			return nil, err
+		}
+		switch d.Kind {
+		case "ptr":
+			return reflect.PtrTo(elem), nil
+		case "slice":
+			return reflect.SliceOf(elem), nil
+		}
+		return reflect.ArrayOf(d.Len, elem), nil
+	case "map":
+		key, err := typeFromDesc(d.Key)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := typeFromDesc(d.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case "struct":
+		fields := make([]reflect.StructField, 0, len(d.Fields))
+		for _, f := range d.Fields {
+			ft, err := typeFromDesc(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, reflect.StructField{Name: f.Name, Type: ft, Tag: reflect.StructTag(fmt.Sprintf(`json:%q`, f.Name))})
+		}
+		return reflect.StructOf(fields), nil
+	}
+	return nil, fmt.Errorf("unsupported kind %s", d.Kind)
+}
+
+//类型的简短写法，比如 Args、*Node、[]int
+func typeString(d *geerpc.TypeDesc) string {
+	if d == nil {
+		return ""
+	}
+	if d.Name != "" {
+		return d.Name
+	}
+	switch d.Kind {
+	case "ptr":
+		return "*" + typeString(d.Elem)
+	case "slice":
+		return "[]" + typeString(d.Elem)
+	case "array":
+		return fmt.Sprintf("[%d]%s", d.Len, typeString(d.Elem))
+	case "map":
+		return "map[" + typeString(d.Key) + "]" + typeString(d.Elem)
+	}
+	return d.Kind
+}
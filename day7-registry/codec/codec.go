@@ -7,6 +7,7 @@ type Header struct {
 	ServiceMethod	string	//	format 服务.方法
 	Seq				uint64	//	序列号
 	Error			string
+	Metadata		map[string]string	//调用方附带的元数据，比如认证、追踪信息，旧版本没有
 }
 
 //	编码接口
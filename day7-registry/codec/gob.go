@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"encoding/gob"
 	"io"
-	"log"
 )
 //gob是golang包自带的一个数据结构序列化的编码/解码工具。
 //
@@ -49,12 +48,10 @@ func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
 		}
 	}()
 	if err := c.enc.Encode(h); err != nil {
-		log.Println("rpc codec: gob error encoding header:", err)
 		return err
 	}
 
 	if err := c.enc.Encode(body); err != nil {
-		log.Println("rpc codec: gob error encoding body", err)
 		return err
 	}
 	return nil
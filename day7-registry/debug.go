@@ -1,28 +1,69 @@
 package geerpc
 
 import (
+	"encoding/json"
 	"fmt"
+	"geerpc/codec"
 	"html/template"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const debugText = `<html>
 	<body>
 	<title>GeeRPC Services</title>
-	{{range .}}
+	Started {{.Start.Format "2006-01-02 15:04:05"}}, uptime {{.Uptime}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
-		{{range $name, $mtype := .Method}}
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th>
+		<th align=center>p50</th><th align=center>p95</th><th align=center>p99</th>
+		{{range .Methods}}
 			<tr>
-			<td aligin=left font=fixed>{{$name}}({{$mtype.ArgType}},{{$mtype.ReplyType}}) error</td>
-			<td aligin=center>{{$mtype.NumCalls}}</td>
+			<td aligin=left font=fixed>{{.Name}}({{.ArgType}},{{.ReplyType}}) error</td>
+			<td aligin=center>{{.Calls}}</td>
+			<td aligin=center>{{.Errors}}</td>
+			<td aligin=center>{{.P50}}</td>
+			<td aligin=center>{{.P95}}</td>
+			<td aligin=center>{{.P99}}</td>
 			<tr>
 		{{end}}
 		</table>
 	{{end}}
+	<hr>
+	In-flight requests
+	<hr>
+		<table>
+		<th align=center>Method</th><th align=center>Seq</th><th align=center>Client</th><th align=center>Elapsed</th>
+		{{range .Requests}}
+			<tr>
+			<td aligin=left font=fixed>{{.ServiceMethod}}</td>
+			<td aligin=center>{{.Seq}}</td>
+			<td aligin=center>{{.Peer}}</td>
+			<td aligin=center>{{.Elapsed}}</td>
+			<tr>
+		{{end}}
+		</table>
+	<hr>
+	Connections
+	<hr>
+		<table>
+		<th align=center>Client</th><th align=center>Codec</th><th align=center>Pending</th><th align=center>Connected</th>
+		{{range .Conns}}
+			<tr>
+			<td aligin=left font=fixed>{{.Peer}}</td>
+			<td aligin=center>{{.Codec}}</td>
+			<td aligin=center>{{.Pending}}</td>
+			<td aligin=center>{{.Connected}}</td>
+			<tr>
+		{{end}}
+		</table>
 	</body>
 	</html>`
 
@@ -32,23 +73,188 @@ type debugHTTP struct {
 	*Server
 }
 
+//调试页面的数据，?format=json 或者 Accept: application/json 时以JSON输出，时间单位为秒
+type debugInfo struct {
+	Start		time.Time		`json:"start"`
+	Uptime		debugDuration	`json:"uptime"`
+	Services	[]debugService	`json:"services"`
+	Requests	[]debugRequest	`json:"requests"`
+	Conns		[]debugConn		`json:"conns"`
+}
+
 type debugService struct {
-	Name 	string
-	Method  map[string]*methodType
+	Name 	string			`json:"name"`
+	Methods	[]debugMethod	`json:"methods"`
 }
 
-func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	var services []debugService
+type debugMethod struct {
+	Name		string			`json:"name"`
+	ArgType		string			`json:"argType"`
+	ReplyType	string			`json:"replyType"`
+	Calls		uint64			`json:"calls"`
+	Errors		uint64			`json:"errors"`
+	P50			debugDuration	`json:"p50"`
+	P95			debugDuration	`json:"p95"`
+	P99			debugDuration	`json:"p99"`
+}
+
+type debugRequest struct {
+	ServiceMethod	string			`json:"serviceMethod"`
+	Seq				uint64			`json:"seq"`
+	Peer			string			`json:"peer"`
+	Elapsed			debugDuration	`json:"elapsed"`
+}
+
+type debugConn struct {
+	Peer		string			`json:"peer"`
+	Codec		codec.Type		`json:"codec"`
+	Pending		int64			`json:"pending"`
+	Connected	debugDuration	`json:"connected"`
+}
+
+//页面上按 time.Duration 显示，JSON 中是秒数
+type debugDuration time.Duration
+
+func (d debugDuration) String() string {
+	return time.Duration(d).Round(time.Microsecond).String()
+}
+
+func (d debugDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).Seconds())
+}
+
+//连接的信息
+type connInfo struct {
+	peer	string
+	codec	codec.Type
+	start	time.Time
+	pending	int64		//原子操作，正在处理的请求数
+
+	sending	sync.Mutex	//互斥发送锁
+	read	int64		//已读取的字节数，只在读请求的协程中修改
+	written	int64		//已发送的字节数，持有 sending 时修改
+}
+
+//记录或移除正在执行的请求，不占用 server.mu
+func (server *Server) trackRequest(req *request, add bool) {
+	if add {
+		server.inflight.Store(req, struct{}{})
+	} else {
+		server.inflight.Delete(req)
+	}
+}
+
+func (server *Server) debugInfo() debugInfo {
+	now := time.Now()
+	info := debugInfo{
+		Start:		server.start,
+		Uptime:		debugDuration(now.Sub(server.start)),
+		Services:	make([]debugService, 0),
+		Requests:	make([]debugRequest, 0),
+		Conns:		make([]debugConn, 0),
+	}
 	server.serviceMap.Range(func(namei, svci interface{}) bool {
 		svc := svci.(*service)
-		services = append(services, debugService{
-			Name: 	namei.(string),
-			Method: svc.method,
+		ds := debugService{Name: namei.(string)}
+		for name, m := range svc.method {
+			p := m.latency.percentiles(0.5, 0.95, 0.99)
+			ds.Methods = append(ds.Methods, debugMethod{
+				Name:		name,
+				ArgType:	m.ArgType.String(),
+				ReplyType:	m.ReplyType.String(),
+				Calls:		m.NumCalls(),
+				Errors:		m.NumErrors(),
+				P50:		debugDuration(p[0]),
+				P95:		debugDuration(p[1]),
+				P99:		debugDuration(p[2]),
+			})
+		}
+		sort.Slice(ds.Methods, func(i, j int) bool { return ds.Methods[i].Name < ds.Methods[j].Name })
+		info.Services = append(info.Services, ds)
+		return true
+	})
+	sort.Slice(info.Services, func(i, j int) bool { return info.Services[i].Name < info.Services[j].Name })
+
+	server.inflight.Range(func(reqi, _ interface{}) bool {
+		req := reqi.(*request)
+		info.Requests = append(info.Requests, debugRequest{
+			ServiceMethod:	req.h.ServiceMethod,
+			Seq:			req.h.Seq,
+			Peer:			req.conn.peer,
+			Elapsed:		debugDuration(now.Sub(req.start)),
 		})
 		return true
 	})
-	err := debug.Execute(w, services)
+	server.mu.Lock()
+	for _, c := range server.conns {
+		info.Conns = append(info.Conns, debugConn{
+			Peer:		c.peer,
+			Codec:		c.codec,
+			Pending:	atomic.LoadInt64(&c.pending),
+			Connected:	debugDuration(now.Sub(c.start)),
+		})
+	}
+	server.mu.Unlock()
+	//执行最久的请求在前
+	sort.Slice(info.Requests, func(i, j int) bool { return info.Requests[i].Elapsed > info.Requests[j].Elapsed })
+	sort.Slice(info.Conns, func(i, j int) bool { return info.Conns[i].Peer < info.Conns[j].Peer })
+	return info
+}
+
+func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	info := server.debugInfo()
+	if req.URL.Query().Get("format") == "json" || strings.Contains(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(info)
+		return
+	}
+	err := debug.Execute(w, info)
 	if err != nil {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
 }
+
+//<-----------------------耗时分位数------------------------->
+
+//保留最近的 latencyWindowSize 个耗时
+const latencyWindowSize = 1024
+
+type latencyWindow struct {
+	mu		sync.Mutex
+	samples	[]time.Duration
+	next	int
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+//最近耗时的分位数，没有样本时为 0
+func (w *latencyWindow) percentiles(ps ...float64) []time.Duration {
+	w.mu.Lock()
+	samples := append([]time.Duration(nil), w.samples...)
+	w.mu.Unlock()
+	ret := make([]time.Duration, len(ps))
+	if len(samples) == 0 {
+		return ret
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	for i, p := range ps {
+		idx := int(p*float64(len(samples))+0.5) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= len(samples) {
+			idx = len(samples) - 1
+		}
+		ret[i] = samples[idx]
+	}
+	return ret
+}
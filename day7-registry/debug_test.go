@@ -0,0 +1,68 @@
+package geerpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugHTTP(t *testing.T) {
+	server := NewServer()
+	var s Slow
+	var p Panicky
+	_ = server.Register(&s)
+	_ = server.Register(&p)
+	client := serveAndDial(t, server)
+	var reply int
+	for i := 0; i < 3; i++ {
+		_ = client.Call(context.Background(), "Slow.Sleep", 1, &reply)
+	}
+	_ = client.Call(context.Background(), "Panicky.Boom", 1, &reply)
+	call := client.Go("Slow.Sleep", 300, &reply, nil)
+	time.Sleep(time.Millisecond * 100)
+
+	ts := httptest.NewServer(debugHTTP{server})
+	defer ts.Close()
+	resp, err := ts.Client().Get(ts.URL + "?format=json")
+	_assert(err == nil, "failed to get debug page: %v", err)
+	var info struct {
+		Uptime   float64
+		Services []struct {
+			Name    string
+			Methods []struct {
+				Name          string
+				Calls, Errors uint64
+				P50, P99      float64
+			}
+		}
+		Requests []struct {
+			ServiceMethod string
+			Elapsed       float64
+		}
+		Conns []struct {
+			Peer    string
+			Codec   string
+			Pending int64
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	_ = resp.Body.Close()
+	_assert(err == nil, "invalid json: %v", err)
+	_assert(info.Uptime > 0 && len(info.Services) == 2, "unexpected info: %+v", info)
+	boom, sleep := info.Services[0].Methods[0], info.Services[1].Methods[0]
+	_assert(boom.Calls == 1 && boom.Errors == 1, "unexpected Boom stats: %+v", boom)
+	_assert(sleep.Calls == 4 && sleep.Errors == 0 && sleep.P50 >= 0.001 && sleep.P99 >= sleep.P50, "unexpected Sleep stats: %+v", sleep)
+	_assert(len(info.Requests) == 1 && info.Requests[0].ServiceMethod == "Slow.Sleep" && info.Requests[0].Elapsed >= 0.05, "unexpected requests: %+v", info.Requests)
+	_assert(len(info.Conns) == 1 && info.Conns[0].Pending == 1 && info.Conns[0].Codec == "application/gob" && info.Conns[0].Peer != "", "unexpected conns: %+v", info.Conns)
+
+	resp, err = ts.Client().Get(ts.URL)
+	_assert(err == nil, "failed to get debug page: %v", err)
+	page, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	_assert(strings.Contains(string(page), "In-flight requests") && strings.Contains(string(page), "Slow.Sleep"), "unexpected page: %s", page)
+	<-call.Done
+}
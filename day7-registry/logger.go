@@ -0,0 +1,103 @@
+package geerpc
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+//日志级别
+type Level int32
+
+const (
+	LevelDebug	Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int32(l))
+}
+
+//日志附带的键值对
+type Field struct {
+	Key		string
+	Value	interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+//常用的字段名
+const (
+	FieldMethod	= "method"	//Service.Method
+	FieldSeq	= "seq"
+	FieldPeer	= "peer"	//对端地址
+	FieldError	= "err"
+)
+
+//可替换的日志接口，Server、Client、XClient 和注册中心都可以单独设置
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+//用标准库 log 输出，格式为 "INFO msg key=value ..."，低于 Level 的日志不输出
+type StdLogger struct {
+	Out		*log.Logger		//为空时使用 log 包的默认输出
+	Level	Level
+}
+
+func (l *StdLogger) Log(level Level, msg string, fields ...Field) {
+	if level < l.Level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		v := fmt.Sprint(f.Value)
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		b.WriteString(v)
+	}
+	if l.Out != nil {
+		_ = l.Out.Output(2, b.String())
+	} else {
+		_ = log.Output(2, b.String())
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(Level, string, ...Field) {}
+
+//丢弃所有日志
+var NopLogger Logger = nopLogger{}
+
+//没有单独设置日志时使用，需在开始服务之前替换
+var DefaultLogger Logger = &StdLogger{Level: LevelInfo}
+
+//l 为空时使用 DefaultLogger
+func loggerOr(l Logger) Logger {
+	if l == nil {
+		return DefaultLogger
+	}
+	return l
+}
@@ -0,0 +1,66 @@
+package geerpc
+
+import (
+	"bytes"
+	"log"
+	"sync"
+	"testing"
+)
+
+//记录所有日志，用于测试
+type captureLogger struct {
+	mu      sync.Mutex
+	entries []captureEntry
+}
+
+type captureEntry struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+func (l *captureLogger) Log(level Level, msg string, fields ...Field) {
+	e := captureEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for _, f := range fields {
+		e.fields[f.Key] = f.Value
+	}
+	l.mu.Lock()
+	l.entries = append(l.entries, e)
+	l.mu.Unlock()
+}
+
+func (l *captureLogger) find(level Level) []captureEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []captureEntry
+	for _, e := range l.entries {
+		if e.level == level {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := &StdLogger{Out: log.New(&buf, "", 0), Level: LevelWarn}
+	l.Log(LevelInfo, "ignored")
+	l.Log(LevelWarn, "rpc server: boom", F(FieldMethod, "Foo.Sum"), F(FieldSeq, 3), F(FieldError, "a b"))
+	expect := "WARN rpc server: boom method=Foo.Sum seq=3 err=\"a b\"\n"
+	_assert(buf.String() == expect, "unexpected output %q", buf.String())
+}
+
+func TestServer_RegisterError(t *testing.T) {
+	var logger captureLogger
+	server := NewServer()
+	server.SetLogger(&logger)
+
+	_assert(server.Register(nil) != nil, "expect error for nil service")
+	_assert(server.Register(new(int)) != nil, "expect error for unexported service name")
+	_assert(len(logger.find(LevelError)) == 2, "expect 2 errors logged, got %d", len(logger.find(LevelError)))
+
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "failed to register Foo")
+	debug := logger.find(LevelDebug)
+	_assert(len(debug) == 1 && debug[0].fields[FieldMethod] == "Foo.Sum", "unexpected registration log %+v", debug)
+}
@@ -0,0 +1,29 @@
+// Code generated by geerpc-gen. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+	"geerpc"
+)
+
+// FooClient 是 Foo 服务的客户端
+type FooClient struct {
+	c geerpc.Caller
+}
+
+func NewFooClient(c geerpc.Caller) *FooClient {
+	return &FooClient{c: c}
+}
+
+func (c *FooClient) Sleep(ctx context.Context, args Args) (int, error) {
+	var reply int
+	err := c.c.Call(ctx, "Foo.Sleep", args, &reply)
+	return reply, err
+}
+
+func (c *FooClient) Sum(ctx context.Context, args Args) (int, error) {
+	var reply int
+	err := c.c.Call(ctx, "Foo.Sum", args, &reply)
+	return reply, err
+}
@@ -12,6 +12,7 @@ import(
 	"context"
 )
 
+//go:generate go run geerpc/cmd/geerpc-gen -type Foo
 type Foo int
 
 type Args struct { 
@@ -33,7 +34,8 @@ func startServer(registryAddr string, wg *sync.WaitGroup){
 	l, _ := net.Listen("tcp", ":0")
 	server := geerpc.NewServer()
 	_ = server.Register(&foo)
-	registry.Heartbeat(registryAddr, "tcp@"+l.Addr().String(), 0)
+	_ = server.EnableReflection()
+	registry.HeartbeatServer(registryAddr, server, registry.ServerItem{Addr: "tcp@" + l.Addr().String()}, 0)
 	wg.Done()
 	server.Accept(l)
 }
@@ -54,6 +56,14 @@ func foo(xc *xclient.XClient, ctx context.Context, typ, serviceMethod string, ar
 		err = xc.Call(ctx, serviceMethod, args, &reply)
 	case "broadcast":
 		err = xc.Broadcast(ctx, serviceMethod, args, &reply)
+	case "fork":
+		err = xc.Fork(ctx, 0, serviceMethod, args, &reply)
+	case "gather":
+		var results xclient.GatherResults
+		results, err = xc.Gather(ctx, serviceMethod, args, &reply, xclient.GatherAll)
+		for addr, r := range results {
+			log.Printf("gather %s from %s: reply=%v err=%v", serviceMethod, addr, r.Reply, r.Error)
+		}
 	}
 	if err != nil {
 		log.Printf("%s %s error: %v", typ, serviceMethod, err)
@@ -92,8 +102,11 @@ func broadcast(registry string) {
 		go func(i int) {
 			defer wg.Done()
 			foo(xc, context.Background(), "broadcast", "Foo.Sum", &Args{Num1: i, Num2: i * i})
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*2)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
 			foo(xc, ctx, "broadcast", "Foo.Sleep", &Args{Num1: i, Num2: i * i})
+			cancel()
+			foo(xc, context.Background(), "gather", "Foo.Sum", &Args{Num1: i, Num2: i * i})
+			foo(xc, context.Background(), "fork", "Foo.Sleep", &Args{Num1: i, Num2: i * i})
 		}(i)
 	}
 	wg.Wait()
@@ -0,0 +1,40 @@
+package geerpc
+
+import "context"
+
+type metadataKey struct{}
+
+//返回带有元数据的 ctx，Client.Call 会把它放到请求头里发给服务端，
+//已有的元数据会和 md 合并，同名的以 md 为准
+func WithMetadata(ctx context.Context, md map[string]string) context.Context {
+	merged := make(map[string]string)
+	for k, v := range MetadataFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+//ctx 中的元数据，没有时为 nil，不要修改返回的 map
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
+
+type incomingMetadataKey struct{}
+
+//服务端收到的元数据，和 WithMetadata 分开，转发请求时不会被自动带上
+func withIncomingMetadata(ctx context.Context, md map[string]string) context.Context {
+	if len(md) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, incomingMetadataKey{}, md)
+}
+
+//RegisterFunc 注册的函数可以用它读取调用方附带的元数据，不要修改返回的 map
+func IncomingMetadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(incomingMetadataKey{}).(map[string]string)
+	return md
+}
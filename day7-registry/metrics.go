@@ -0,0 +1,138 @@
+package geerpc
+
+import (
+	"errors"
+	"geerpc/metrics"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//服务端的指标，在 defaultMetricsPath 以 Prometheus 文本格式输出
+type serverMetrics struct {
+	registry	*metrics.Registry
+	requests	*metrics.CounterVec		//service, method
+	errors		*metrics.CounterVec		//service, method, code
+	latency		*metrics.HistogramVec	//service, method
+	inFlight	*metrics.GaugeVec		//service, method
+	panics		*metrics.CounterVec		//service, method
+	bytesIn		*metrics.Counter
+	bytesOut	*metrics.Counter
+	conns		*metrics.Gauge
+}
+
+//错误的分类，用作 errors 指标的 code 标签
+const (
+	codeError		= "error"		//服务方法返回了错误
+	codePanic		= "panic"
+	codeTimeout		= "timeout"		//超过 HandleTimeout
+	codeNotFound	= "not_found"	//服务或方法不存在
+	codeBadRequest	= "bad_request"	//请求体解码失败
+	codeShutdown	= "shutdown"	//服务器正在关闭
+)
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		registry:	r,
+		requests:	r.NewCounterVec("geerpc_server_requests_total", "Requests handled, by method.", "service", "method"),
+		errors:		r.NewCounterVec("geerpc_server_errors_total", "Failed requests, by method and error code.", "service", "method", "code"),
+		latency:	r.NewHistogramVec("geerpc_server_request_duration_seconds", "Time spent handling requests.", nil, "service", "method"),
+		inFlight:	r.NewGaugeVec("geerpc_server_in_flight_requests", "Requests currently being handled.", "service", "method"),
+		panics:		r.NewCounterVec("geerpc_server_panics_total", "Panics recovered in service methods.", "service", "method"),
+		bytesIn:	r.NewCounter("geerpc_server_received_bytes_total", "Bytes read from client connections."),
+		bytesOut:	r.NewCounter("geerpc_server_sent_bytes_total", "Bytes written to client connections."),
+		conns:		r.NewGauge("geerpc_server_active_connections", "Client connections currently open."),
+	}
+}
+
+//服务器的指标，可以挂到其他的 HTTP 路径上
+func (server *Server) Metrics() *metrics.Registry {
+	return server.getMetrics().registry
+}
+
+//零值的 Server 也可以使用，指标在第一次使用时创建
+func (server *Server) getMetrics() *serverMetrics {
+	server.metricsOnce.Do(func() {
+		server.metrics = newServerMetrics()
+	})
+	return server.metrics
+}
+
+//拆分 Service.Method，格式不对时都为 unknown，避免标签值过多
+func splitServiceMethod(serviceMethod string) (string, string) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return "unknown", "unknown"
+	}
+	return serviceMethod[:dot], serviceMethod[dot+1:]
+}
+
+//没有进入 handleRequest 的失败请求
+func (m *serverMetrics) reject(serviceMethod, code string) {
+	service, method := "unknown", "unknown"
+	if code != codeNotFound {
+		service, method = splitServiceMethod(serviceMethod)
+	}
+	m.requests.With(service, method).Inc()
+	m.errors.With(service, method, code).Inc()
+}
+
+//请求的处理结果，code 为空表示成功
+func (m *serverMetrics) done(req *request, start time.Time, code string) {
+	service, method := req.svc.name, req.mtype.method.Name
+	m.requests.With(service, method).Inc()
+	m.latency.With(service, method).Observe(time.Since(start).Seconds())
+	if code != "" {
+		m.errors.With(service, method, code).Inc()
+	}
+	if code == codePanic {
+		m.panics.With(service, method).Inc()
+	}
+}
+
+//服务方法的错误分类
+func errorCode(err error) string {
+	var pe *panicError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &pe):
+		return codePanic
+	}
+	return codeError
+}
+
+//统计读写字节数的连接
+type countingConn struct {
+	io.ReadWriteCloser
+	in, out	*metrics.Counter
+	closed	int32
+	conns	*metrics.Gauge
+}
+
+func (m *serverMetrics) countConn(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	m.conns.Inc()
+	return &countingConn{ReadWriteCloser: conn, in: m.bytesIn, out: m.bytesOut, conns: m.conns}
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.in.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.out.Add(float64(n))
+	return n, err
+}
+
+//连接可能被关闭多次，只减一次
+func (c *countingConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.conns.Dec()
+	}
+	return c.ReadWriteCloser.Close()
+}
@@ -0,0 +1,368 @@
+//metrics 实现了不依赖第三方库的计数器、仪表盘和直方图，
+//并按 Prometheus 的文本格式输出，用于 /debug/geerpc/metrics 等接口。
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//默认的延迟分桶，单位秒
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+//一组指标，按注册顺序输出
+type Registry struct {
+	mu		sync.Mutex
+	metrics	[]metric
+	names	map[string]bool
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+//按文本格式输出全部指标
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+type countWriter struct {
+	w	io.Writer
+	n	int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+//<-----------------------带标签的指标------------------------->
+
+//指标的公共部分：名字、说明和按标签值索引的子指标
+type family struct {
+	name	string
+	help	string
+	typ		string
+	labels	[]string
+	mu		sync.Mutex
+	values	map[string][]string		//key -> 标签值
+	members	map[string]interface{}	//key -> *Counter、*Gauge 或 *Histogram
+}
+
+func newFamily(name, help, typ string, labels []string) *family {
+	return &family{
+		name:		name,
+		help:		help,
+		typ:		typ,
+		labels:		labels,
+		values:		make(map[string][]string),
+		members:	make(map[string]interface{}),
+	}
+}
+
+//取出标签值对应的子指标，不存在时用 create 新建
+func (f *family) with(values []string, create func() interface{}) interface{} {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.members[key]
+	if !ok {
+		m = create()
+		f.members[key] = m
+		f.values[key] = append([]string(nil), values...)
+	}
+	return m
+}
+
+//按标签值排序后逐个输出
+func (f *family) write(w *bufio.Writer, each func(labels string, m interface{})) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.members))
+	for k := range f.members {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	type entry struct {
+		labels	string
+		m		interface{}
+	}
+	entries := make([]entry, 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, entry{formatLabels(f.labels, f.values[k]), f.members[k]})
+	}
+	f.mu.Unlock()
+	for _, e := range entries {
+		each(e.labels, e.m)
+	}
+}
+
+//{a="1",b="2"}，没有标签时为空
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+//在已有的标签后追加一个标签，用于直方图的 le
+func appendLabel(labels, name, value string) string {
+	pair := name + `="` + escapeLabel(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+var (
+	labelEscaper	= strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper		= strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func escapeHelp(v string) string {
+	return helpEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//<-----------------------计数器和仪表盘------------------------->
+
+//并发安全的 float64
+type value struct {
+	bits	uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		n := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (v *value) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+//只增不减的计数器
+type Counter struct {
+	value
+}
+
+func (c *Counter) Inc() {
+	c.add(1)
+}
+
+//delta 不能为负数
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.add(delta)
+}
+
+//可增可减的仪表盘
+type Gauge struct {
+	value
+}
+
+func (g *Gauge) Inc() {
+	g.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.add(-1)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.add(delta)
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+type CounterVec struct {
+	*family
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newFamily(name, help, "counter", labels)}
+	r.register(name, v)
+	return v
+}
+
+//没有标签的计数器
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.with(values, func() interface{} { return new(Counter) }).(*Counter)
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.family.write(w, func(labels string, m interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labels, formatFloat(m.(*Counter).Value()))
+	})
+}
+
+type GaugeVec struct {
+	*family
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newFamily(name, help, "gauge", labels)}
+	r.register(name, v)
+	return v
+}
+
+//没有标签的仪表盘
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.with(values, func() interface{} { return new(Gauge) }).(*Gauge)
+}
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.family.write(w, func(labels string, m interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labels, formatFloat(m.(*Gauge).Value()))
+	})
+}
+
+//<-----------------------直方图------------------------->
+
+type Histogram struct {
+	mu		sync.Mutex
+	buckets	[]float64	//各个桶的上界，递增
+	counts	[]uint64	//落在各个桶里的个数，不累加
+	sum		float64
+	count	uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+//观测值的个数和总和
+func (h *Histogram) Count() (uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count, h.sum
+}
+
+type HistogramVec struct {
+	*family
+	buckets	[]float64
+}
+
+//buckets 为空时使用 DefBuckets
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	v := &HistogramVec{family: newFamily(name, help, "histogram", labels), buckets: buckets}
+	r.register(name, v)
+	return v
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.with(values, func() interface{} {
+		return &Histogram{buckets: v.buckets, counts: make([]uint64, len(v.buckets))}
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.family.write(w, func(labels string, m interface{}) {
+		h := m.(*Histogram)
+		h.mu.Lock()
+		counts := append([]uint64(nil), h.counts...)
+		sum, count := h.sum, h.count
+		h.mu.Unlock()
+		var cum uint64
+		for i, upper := range h.buckets {
+			cum += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, appendLabel(labels, "le", formatFloat(upper)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, appendLabel(labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, labels, formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, labels, count)
+	})
+}
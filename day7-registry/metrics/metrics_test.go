@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("requests_total", "Requests.", "method")
+	c.With("Foo.Sum").Inc()
+	c.With("Foo.Sum").Add(2)
+	c.With(`a"b`).Inc()
+	g := r.NewGauge("conns", "Open connections.")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "method")
+	h.With("Foo.Sum").Observe(0.05)
+	h.With("Foo.Sum").Observe(0.1)
+	h.With("Foo.Sum").Observe(5)
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE requests_total counter\n",
+		`requests_total{method="Foo.Sum"} 3` + "\n",
+		`requests_total{method="a\"b"} 1` + "\n",
+		"# TYPE conns gauge\nconns 1\n",
+		"# TYPE latency_seconds histogram\n",
+		`latency_seconds_bucket{method="Foo.Sum",le="0.1"} 2` + "\n",
+		`latency_seconds_bucket{method="Foo.Sum",le="1"} 2` + "\n",
+		`latency_seconds_bucket{method="Foo.Sum",le="+Inf"} 3` + "\n",
+		`latency_seconds_sum{method="Foo.Sum"} 5.15` + "\n",
+		`latency_seconds_count{method="Foo.Sum"} 3` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expect %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestRegistry_Duplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("x", "")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect a panic for a duplicate metric")
+		}
+	}()
+	r.NewGauge("x", "")
+}
@@ -0,0 +1,60 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+type Panicky int
+
+func (p Panicky) Boom(n int, reply *int) error {
+	panic("boom")
+}
+
+func TestServer_Metrics(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	var p Panicky
+	_ = server.Register(&foo)
+	_ = server.Register(&p)
+	client := serveAndDial(t, server)
+	var reply int
+	_assert(client.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply) == nil, "Foo.Sum failed")
+	err := client.Call(context.Background(), "Panicky.Boom", 1, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "panic in Panicky.Boom: boom"), "expect a panic error, got %v", err)
+	_assert(client.Call(context.Background(), "Nope.Sum", 1, &reply) != nil, "expect an unknown service error")
+	//panic 之后连接仍然可用
+	_assert(client.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply) == nil, "Foo.Sum failed after panic")
+
+	var buf bytes.Buffer
+	_, _ = server.Metrics().WriteTo(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		`geerpc_server_requests_total{service="Foo",method="Sum"} 2`,
+		`geerpc_server_errors_total{service="Panicky",method="Boom",code="panic"} 1`,
+		`geerpc_server_errors_total{service="unknown",method="unknown",code="not_found"} 1`,
+		`geerpc_server_panics_total{service="Panicky",method="Boom"} 1`,
+		`geerpc_server_request_duration_seconds_count{service="Foo",method="Sum"} 2`,
+		`geerpc_server_in_flight_requests{service="Foo",method="Sum"} 0`,
+		"geerpc_server_active_connections 1",
+	} {
+		_assert(strings.Contains(out, want), "expect %q in metrics:\n%s", want, out)
+	}
+	_assert(!strings.Contains(out, "geerpc_server_received_bytes_total 0\n"), "expect received bytes to be counted")
+}
+
+//零值的 Server 不经过 NewServer 也能服务
+func TestServer_ZeroValue(t *testing.T) {
+	var server Server
+	var foo Foo
+	_ = server.Register(&foo)
+	client := serveAndDial(t, &server)
+	var reply int
+	_assert(client.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply) == nil && reply == 3, "Foo.Sum failed")
+	_assert(client.Call(context.Background(), "Nope.Sum", 1, &reply) != nil, "expect an unknown service error")
+	var buf bytes.Buffer
+	_, _ = server.Metrics().WriteTo(&buf)
+	_assert(strings.Contains(buf.String(), `geerpc_server_requests_total{service="Foo",method="Sum"} 1`), "expect Foo.Sum to be counted")
+}
@@ -0,0 +1,126 @@
+package geerpc
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+)
+
+//内置的 Reflection 服务，用数据描述已注册的服务、方法和参数类型，
+//供命令行、网关等工具动态构造请求。用 Server.EnableReflection 开启。
+
+//类型描述，结构同 reflect.Type 的子集
+type TypeDesc struct {
+	Name		string		//有名字的类型的名字，比如 Args、int
+	PkgPath		string		//定义类型的包，内置类型为空
+	Kind		string		//reflect.Kind 的名字，比如 int64、struct、slice、ptr
+	Elem		*TypeDesc	//指针、切片、数组、map 的元素类型
+	Key			*TypeDesc	//map 的键类型
+	Len			int			//数组的长度
+	Fields		[]FieldDesc	//结构体的导出字段，gob 只编码这些字段
+	Recursive	bool		//递归引用了外层的同名类型，不再展开
+}
+
+type FieldDesc struct {
+	Name	string
+	Type	*TypeDesc
+}
+
+type MethodDesc struct {
+	Name		string
+	ArgType		*TypeDesc
+	ReplyType	*TypeDesc	//总是指针
+	NumCalls	uint64
+}
+
+type ServiceDesc struct {
+	Name	string
+	Methods	[]MethodDesc	//按名字排序
+}
+
+//生成 t 的描述，path 记录外层的有名字的类型，防止递归类型无限展开
+func describeType(t reflect.Type, path map[reflect.Type]bool) *TypeDesc {
+	d := &TypeDesc{Name: t.Name(), PkgPath: t.PkgPath(), Kind: t.Kind().String()}
+	if t.Name() != "" {
+		if path[t] {
+			d.Recursive = true
+			return d
+		}
+		path[t] = true
+		defer delete(path, t)
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		d.Elem = describeType(t.Elem(), path)
+	case reflect.Array:
+		d.Elem, d.Len = describeType(t.Elem(), path), t.Len()
+	case reflect.Map:
+		d.Key, d.Elem = describeType(t.Key(), path), describeType(t.Elem(), path)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			d.Fields = append(d.Fields, FieldDesc{Name: f.Name, Type: describeType(f.Type, path)})
+		}
+	}
+	return d
+}
+
+func (s *service) describe() ServiceDesc {
+	desc := ServiceDesc{Name: s.name}
+	for name, m := range s.method {
+		desc.Methods = append(desc.Methods, MethodDesc{
+			Name:		name,
+			ArgType:	describeType(m.ArgType, make(map[reflect.Type]bool)),
+			ReplyType:	describeType(m.ReplyType, make(map[reflect.Type]bool)),
+			NumCalls:	m.NumCalls(),
+		})
+	}
+	sort.Slice(desc.Methods, func(i, j int) bool { return desc.Methods[i].Name < desc.Methods[j].Name })
+	return desc
+}
+
+//Reflection 服务的实现
+type Reflection struct {
+	server	*Server
+}
+
+//注册 Reflection 服务
+func (server *Server) EnableReflection() error {
+	return server.Register(&Reflection{server: server})
+}
+
+//开启默认服务器的 Reflection 服务
+func EnableReflection() error {
+	return DefaultServer.EnableReflection()
+}
+
+//已注册的服务名，参数不使用
+func (r *Reflection) ListServices(_ string, reply *[]string) error {
+	*reply = r.server.Services()
+	return nil
+}
+
+//描述一个服务
+func (r *Reflection) DescribeService(name string, reply *ServiceDesc) error {
+	svci, ok := r.server.serviceMap.Load(name)
+	if !ok {
+		return errors.New("rpc server: can't find service " + name)
+	}
+	*reply = svci.(*service).describe()
+	return nil
+}
+
+//描述所有服务，按名字排序
+func (r *Reflection) Describe(_ string, reply *[]ServiceDesc) error {
+	descs := make([]ServiceDesc, 0)
+	for _, name := range r.server.Services() {
+		if svci, ok := r.server.serviceMap.Load(name); ok {
+			descs = append(descs, svci.(*service).describe())
+		}
+	}
+	*reply = descs
+	return nil
+}
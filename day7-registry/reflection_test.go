@@ -0,0 +1,49 @@
+package geerpc
+
+import (
+	"context"
+	"testing"
+)
+
+type Node struct {
+	Value    int
+	Children []*Node
+	Attrs    map[string]float64
+	hidden   bool
+}
+
+type Tree int
+
+func (t Tree) Walk(root *Node, reply *[]int) error {
+	return nil
+}
+
+func TestReflection(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	var tree Tree
+	_ = server.Register(&foo)
+	_ = server.Register(&tree)
+	_assert(server.EnableReflection() == nil, "failed to enable reflection")
+	client := serveAndDial(t, server)
+
+	var names []string
+	err := client.Call(context.Background(), "Reflection.ListServices", "", &names)
+	_assert(err == nil && len(names) == 3, "expect 3 services, got %v %v", names, err)
+
+	var desc ServiceDesc
+	err = client.Call(context.Background(), "Reflection.DescribeService", "Tree", &desc)
+	_assert(err == nil && len(desc.Methods) == 1, "unexpected desc: %+v %v", desc, err)
+	arg := desc.Methods[0].ArgType
+	_assert(arg.Kind == "ptr" && arg.Elem.Name == "Node" && arg.Elem.Kind == "struct", "unexpected arg type: %+v", arg)
+	fields := arg.Elem.Fields
+	_assert(len(fields) == 3, "expect only exported fields, got %+v", fields)
+	children := fields[1].Type.Elem.Elem
+	_assert(fields[1].Name == "Children" && children.Name == "Node" && children.Recursive, "expect recursive Node, got %+v", children)
+	_assert(fields[2].Type.Kind == "map" && fields[2].Type.Key.Kind == "string" && fields[2].Type.Elem.Kind == "float64", "unexpected map: %+v", fields[2].Type)
+	reply := desc.Methods[0].ReplyType
+	_assert(reply.Kind == "ptr" && reply.Elem.Kind == "slice" && reply.Elem.Elem.Kind == "int", "unexpected reply type: %+v", reply)
+
+	err = client.Call(context.Background(), "Reflection.DescribeService", "Nope", &desc)
+	_assert(err != nil, "expect an error for an unknown service")
+}
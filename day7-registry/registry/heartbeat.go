@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geerpc"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//心跳的控制句柄，可以停止心跳或者主动注销
+type HeartbeatHandle struct {
+	registry	string
+	item		ServerItem
+	services	func() []string		//每次心跳时取服务名，可以为空
+	duration	time.Duration
+	logger		geerpc.Logger
+	stop		chan struct{}
+	done		chan struct{}
+	once		sync.Once
+}
+
+//心跳间隔的随机抖动比例，避免大量服务器同时发送心跳
+const heartbeatJitter = 0.1
+
+//心跳失败后第一次重试的等待时间，之后翻倍，最多等到一个心跳周期
+var heartbeatRetryMin = time.Second
+
+var errNoRegistry = errors.New("rpc registry: no registry address")
+
+var heartbeatClient = &http.Client{Timeout: time.Second * 10}
+
+//registry 可以是逗号分隔的多个注册中心地址，心跳发给第一个可用的注册中心
+func Heartbeat(registry, addr string, duration time.Duration) *HeartbeatHandle {
+	return startHeartbeat(registry, ServerItem{Addr: addr}, duration, nil, geerpc.DefaultLogger)
+}
+
+//同 Heartbeat，上报 item 中的地址和元数据，服务名在每次心跳时
+//从 server 当前注册的服务中取，注册中心据此按服务返回服务器。
+//server 优雅关闭（Shutdown）时会自动从注册中心注销。心跳的日志使用 server 的日志。
+func HeartbeatServer(registry string, server *geerpc.Server, item ServerItem, duration time.Duration) *HeartbeatHandle {
+	if item.Start.IsZero() {
+		item.Start = time.Now()
+	}
+	h := startHeartbeat(registry, item, duration, server.Services, server.Logger())
+	server.RegisterOnShutdown(func() {
+		if err := h.Deregister(); err != nil {
+			h.logger.Log(geerpc.LevelWarn, "rpc registry: deregister failed", geerpc.F(geerpc.FieldPeer, item.Addr), geerpc.F(geerpc.FieldError, err))
+		}
+	})
+	return h
+}
+
+//先同步发送一次心跳，之后在后台按周期发送
+func startHeartbeat(registry string, item ServerItem, duration time.Duration, services func() []string, logger geerpc.Logger) *HeartbeatHandle {
+	if duration == 0 {
+		duration = defaultTimeout - time.Duration(1)*time.Minute
+	}
+	h := &HeartbeatHandle{
+		registry:	registry,
+		item:		item,
+		services:	services,
+		duration:	duration,
+		logger:		logger,
+		stop:		make(chan struct{}),
+		done:		make(chan struct{}),
+	}
+	err := sendHeartbeat(registry, item, services, logger)
+	go h.loop(err)
+	return h
+}
+
+//失败后按指数退避重试，不会放弃
+func (h *HeartbeatHandle) loop(err error) {
+	defer close(h.done)
+	retry := heartbeatRetryMin
+	for {
+		wait := jitter(h.duration)
+		if err != nil {
+			wait = jitter(retry)
+			if retry *= 2; retry > h.duration {
+				retry = h.duration
+			}
+		} else {
+			retry = heartbeatRetryMin
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-h.stop:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		err = sendHeartbeat(h.registry, h.item, h.services, h.logger)
+	}
+}
+
+//在 d 的基础上随机浮动 ±heartbeatJitter
+func jitter(d time.Duration) time.Duration {
+	delta := float64(d) * heartbeatJitter
+	return d + time.Duration(delta*(2*rand.Float64()-1))
+}
+
+//停止心跳，注册中心里的记录会在超时后被删除
+func (h *HeartbeatHandle) Stop() {
+	h.once.Do(func() {
+		close(h.stop)
+	})
+	<-h.done
+}
+
+//停止心跳并立即从注册中心注销
+func (h *HeartbeatHandle) Deregister() error {
+	h.Stop()
+	return sendDeregister(h.registry, h.item.Addr, h.logger)
+}
+
+func sendHeartbeat(registry string, item ServerItem, services func() []string, logger geerpc.Logger) error {
+	logger.Log(geerpc.LevelDebug, "rpc registry: send heartbeat", geerpc.F(geerpc.FieldPeer, item.Addr), geerpc.F("registry", registry))
+	if services != nil {
+		item.Services = services()
+	}
+	body, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	//注册中心之间会互相复制，发给任意一个成功即可
+	err = errNoRegistry
+	for _, addr := range SplitRegistries(registry) {
+		req, _ := http.NewRequest("POST", addr, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		//旧版本的注册中心只认请求头
+		req.Header.Set("X-Geerpc-Server", item.Addr)
+		if len(item.Services) > 0 {
+			req.Header.Set("X-Geerpc-Services", strings.Join(item.Services, ","))
+		}
+		if item.Version != "" {
+			req.Header.Set("X-Geerpc-Version", item.Version)
+		}
+		if err = doRegistryRequest(req); err == nil {
+			return nil
+		}
+		logger.Log(geerpc.LevelWarn, "rpc registry: heartbeat failed", geerpc.F(geerpc.FieldPeer, item.Addr), geerpc.F("registry", addr), geerpc.F(geerpc.FieldError, err))
+	}
+	return err
+}
+
+func sendDeregister(registry, addr string, logger geerpc.Logger) error {
+	logger.Log(geerpc.LevelInfo, "rpc registry: deregister", geerpc.F(geerpc.FieldPeer, addr), geerpc.F("registry", registry))
+	err := errNoRegistry
+	for _, r := range SplitRegistries(registry) {
+		req, _ := http.NewRequest("DELETE", r, nil)
+		req.Header.Set("X-Geerpc-Server", addr)
+		if err = doRegistryRequest(req); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+//拆分逗号分隔的地址或名字列表，去掉空白，忽略空的项
+func SplitRegistries(registry string) []string {
+	var ret []string
+	for _, addr := range strings.Split(registry, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			ret = append(ret, addr)
+		}
+	}
+	return ret
+}
+
+func doRegistryRequest(req *http.Request) error {
+	return doRequest(heartbeatClient, req)
+}
+
+//发送请求，非 200 的回复也算错误
+func doRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatHandle(t *testing.T) {
+	r := New(0)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	h := Heartbeat(ts.URL, "tcp@a", time.Millisecond*20)
+	if servers := addrs(r.aliveServers()); len(servers) != 1 {
+		t.Fatalf("expect tcp@a registered, got %v", servers)
+	}
+	if err := h.Deregister(); err != nil {
+		t.Fatal(err)
+	}
+	if servers := addrs(r.aliveServers()); len(servers) != 0 {
+		t.Fatalf("expect tcp@a deregistered, got %v", servers)
+	}
+	//已经停止，不会再注册回去
+	time.Sleep(time.Millisecond * 50)
+	if servers := addrs(r.aliveServers()); len(servers) != 0 {
+		t.Fatalf("expect heartbeat stopped, got %v", servers)
+	}
+}
+
+func TestHeartbeatHandle_Retry(t *testing.T) {
+	heartbeatRetryMin = time.Millisecond * 10
+	defer func() { heartbeatRetryMin = time.Second }()
+
+	//前几次心跳失败，之后恢复
+	r := New(0)
+	var failures int32 = 3
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+
+	h := Heartbeat(ts.URL, "tcp@a", time.Hour)
+	defer h.Stop()
+	for i := 0; i < 100 && len(r.aliveServers()) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if servers := addrs(r.aliveServers()); len(servers) != 1 {
+		t.Fatalf("expect heartbeat to retry until registered, got %v", servers)
+	}
+}
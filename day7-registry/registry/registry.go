@@ -1,117 +1,321 @@
 package registry
 
 import(
+	"encoding/json"
 	"time"
 	"sync"
 	"sort"
+	"strconv"
 	"strings"
-	"log"
 	"net/http"
+	"reflect"
+	"geerpc"
 )
 
 type GeeRegistry struct {
 	timeout time.Duration
 	mu		sync.Mutex
 	servers map[string]*ServerItem
+	index	uint64			//服务列表的版本号，每次增删服务都会加一
+	changed	chan struct{}	//版本号变化时关闭，唤醒等待中的watch请求
+
+	//多个注册中心之间的复制，见 replication.go
+	peers		[]string				//其他注册中心的地址
+	removed		map[string]time.Time	//主动注销的服务及注销时间，防止被旧数据复制回来
+	stopSync	chan struct{}
+
+	//快照，见 snapshot.go
+	snapshotPath	string
+	stopSnapshot	chan struct{}
+	snapshotDone	chan struct{}
+
+	logger	geerpc.Logger	//为空时使用 geerpc.DefaultLogger
 }
 
+//服务实例及其元数据，也是注册中心JSON接口的格式
 type ServerItem struct {
-	Addr 	string
-	start	time.Time
+	Addr 		string		`json:"addr"`
+	Services	[]string	`json:"services,omitempty"`	//该服务器注册的服务名
+	Version		string		`json:"version,omitempty"`	//可选的版本号
+	Weight		int			`json:"weight,omitempty"`	//负载权重，0 表示默认权重
+	Zone		string		`json:"zone,omitempty"`		//所在的可用区
+	Tags		[]string	`json:"tags,omitempty"`
+	Start		time.Time	`json:"start"`				//服务器的启动时间
+	Updated		time.Time	`json:"updated"`			//最近一次心跳的时间，由注册中心填写
+	Unconfirmed	bool		`json:"unconfirmed,omitempty"`	//从快照恢复、还没有重新发送心跳
+}
+
+//GET 请求的JSON回复
+type ServerList struct {
+	Index	uint64			`json:"index"`
+	Servers	[]ServerItem	`json:"servers"`
+}
+
+//除心跳时间外的元数据是否相同
+func (s *ServerItem) sameMeta(o *ServerItem) bool {
+	a, b := *s, *o
+	a.Updated, b.Updated = time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
+}
+
+//是否提供了某个服务，service 为空时总是提供
+func (s *ServerItem) hasService(service string) bool {
+	if service == "" {
+		return true
+	}
+	for _, name := range s.Services {
+		if name == service {
+			return true
+		}
+	}
+	return false
 }
 
 const (
 	defaultPath		= "/_geerpc_/registry"
 	defaultTimeout	= time.Minute * 5
+	//watch请求默认最多挂起的时间
+	defaultWatchWait	= time.Second * 30
 )
 //创建注册中心实例，且设置超时时间。
 func New(timeout time.Duration) *GeeRegistry {
 	return &GeeRegistry {
 		servers : make(map[string]*ServerItem),
 		timeout : timeout,
+		index	: 1,
+		changed	: make(chan struct{}),
+		removed	: make(map[string]time.Time),
 	}
 }
 
 var DefaultGeeRegister = New(defaultTimeout)
 
-//添加服务实例、更新start时间
-func (r *GeeRegistry) putServer(addr string) {
+//添加服务实例、更新心跳时间，元数据变化也算列表变化
+func (r *GeeRegistry) putServer(item ServerItem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.Strings(item.Services)
+	item.Updated = time.Now()
+	item.Unconfirmed = false
+	delete(r.removed, item.Addr)
+	s := r.servers[item.Addr]
+	if s == nil || !s.sameMeta(&item) {
+		r.bump()
+	}
+	r.servers[item.Addr] = &item
+}
+
+//删除服务实例
+func (r *GeeRegistry) removeServer(addr string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	s := r.servers[addr]
-	if s == nil {
-		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
-	} else {
-		s.start = time.Now()
+	r.removed[addr] = time.Now()
+	if _, ok := r.servers[addr]; ok {
+		delete(r.servers, addr)
+		r.bump()
 	}
 }
 
+//服务列表发生变化，需持有锁
+func (r *GeeRegistry) bump() {
+	r.index++
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+//服务列表的过滤条件，零值表示不过滤
+type serverFilter struct {
+	service	string
+	version	string
+}
+
+func (f serverFilter) match(s *ServerItem) bool {
+	return s.hasService(f.service) && (f.version == "" || f.version == s.Version)
+}
+
 //获取可用的服务列表，删除超时服务
-func (r *GeeRegistry) aliveServers() []string {
+func (r *GeeRegistry) aliveServers() []ServerItem {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	var alive[] string
+	return r.aliveLocked(serverFilter{})
+}
+
+//同上，只返回满足过滤条件的服务的拷贝，按地址排序，需持有锁
+func (r *GeeRegistry) aliveLocked(filter serverFilter) []ServerItem {
+	var alive[] ServerItem
+	removed := false
 	for addr, s := range r.servers {
-		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
-			alive = append(alive, addr)
+		if r.timeout == 0 || s.Updated.Add(r.timeout).After(time.Now()) {
+			if filter.match(s) {
+				alive = append(alive, *s)
+			}
 		} else {
 			delete(r.servers, addr)
+			removed = true
 		}
 	}
-	sort.Strings(alive)
+	if removed {
+		r.bump()
+	}
+	sort.Slice(alive, func(i, j int) bool { return alive[i].Addr < alive[j].Addr })
 	return alive
 }
 
+//只取地址
+func addrs(items []ServerItem) []string {
+	ret := make([]string, 0, len(items))
+	for _, item := range items {
+		ret = append(ret, item.Addr)
+	}
+	return ret
+}
+
+//长轮询：版本号与 index 不同时立即返回，否则挂起直到服务列表变化、
+//有服务超时或者等待了 wait 时间。返回服务列表和当前版本号。
+func (r *GeeRegistry) watchServers(done <-chan struct{}, filter serverFilter, index uint64, wait time.Duration) ([]ServerItem, uint64) {
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	for {
+		r.mu.Lock()
+		alive := r.aliveLocked(filter)
+		cur, changed := r.index, r.changed
+		//最早超时的服务，到时间要醒来把它删掉
+		var expire <-chan time.Time
+		if r.timeout != 0 && len(r.servers) > 0 {
+			var first time.Time
+			for _, s := range r.servers {
+				if first.IsZero() || s.Updated.Before(first) {
+					first = s.Updated
+				}
+			}
+			expire = time.After(time.Until(first.Add(r.timeout)))
+		}
+		r.mu.Unlock()
+
+		if cur != index {
+			return alive, cur
+		}
+		select {
+		case <-changed:
+		case <-expire:
+		case <-deadline.C:
+			return alive, cur
+		case <-done:
+			return alive, cur
+		}
+	}
+}
+
 
 func (r *GeeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
-		w.Header().Set("X-Geerpc-Servers", strings.Join(r.aliveServers(), ","))
+		//可以按服务名和版本过滤
+		filter := serverFilter{
+			service:	req.URL.Query().Get("service"),
+			version:	req.URL.Query().Get("version"),
+		}
+		//带 index 参数时是watch请求
+		if index := req.URL.Query().Get("index"); index != "" {
+			r.serveWatch(w, req, filter, index)
+			return
+		}
+		r.mu.Lock()
+		alive, cur := r.aliveLocked(filter), r.index
+		r.mu.Unlock()
+		writeServers(w, req, alive, cur)
 	case "POST":
-		addr := req.Header.Get("X-Geerpc-Server")
-		if addr == "" {
+		item, err := readServerItem(req)
+		if err != nil || item.Addr == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.putServer(item)
+		r.replicate(req, item)
+	case "DELETE":
+		//服务器主动注销
+		item, err := readServerItem(req)
+		if err != nil || item.Addr == "" {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		r.putServer(addr)
+		r.removeServer(item.Addr)
+		r.replicate(req, item)
+	case "PUT":
+		//其他注册中心同步过来的完整列表
+		var list ServerList
+		if err := json.NewDecoder(req.Body).Decode(&list); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.merge(list.Servers)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func (r *GeeRegistry) HandleHTTP(registryPath string) {
-	http.Handle(registryPath, r)
-	log.Println("rpc registry path:", registryPath)
+//心跳的body是JSON格式的 ServerItem；旧版本只有 X-Geerpc-Server 等请求头
+func readServerItem(req *http.Request) (ServerItem, error) {
+	var item ServerItem
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(req.Body).Decode(&item)
+		return item, err
+	}
+	item.Addr = req.Header.Get("X-Geerpc-Server")
+	item.Services = SplitRegistries(req.Header.Get("X-Geerpc-Services"))
+	item.Version = req.Header.Get("X-Geerpc-Version")
+	return item, nil
 }
 
-func HandleHTTP() {
-	DefaultGeeRegister.HandleHTTP(defaultPath)
+//总是写 X-Geerpc-Servers 头以兼容旧的客户端，请求JSON时再把完整的元数据写到body
+func writeServers(w http.ResponseWriter, req *http.Request, items []ServerItem, index uint64) {
+	w.Header().Set("X-Geerpc-Servers", strings.Join(addrs(items), ","))
+	w.Header().Set("X-Geerpc-Index", strconv.FormatUint(index, 10))
+	if req.URL.Query().Get("format") != "json" && !strings.Contains(req.Header.Get("Accept"), "application/json") {
+		return
+	}
+	if items == nil {
+		items = []ServerItem{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(ServerList{Index: index, Servers: items})
 }
 
-
-func Heartbeat(registry, addr string, duration time.Duration) {
-	if duration == 0 {
-		duration = defaultTimeout - time.Duration(1)*time.Minute
+//GET ?index=N&wait=30s，版本号变化后才返回
+func (r *GeeRegistry) serveWatch(w http.ResponseWriter, req *http.Request, filter serverFilter, index string) {
+	idx, err := strconv.ParseUint(index, 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
-	var err error
-	err = sendHeartbeat(registry, addr)
-	go func() {
-		t := time.NewTicker(duration)
-		for err == nil {
-			<-t.C
-			err = sendHeartbeat(registry, addr)
+	wait := defaultWatchWait
+	if v := req.URL.Query().Get("wait"); v != "" {
+		if wait, err = time.ParseDuration(v); err != nil || wait <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
-	}()
+	}
+	alive, cur := r.watchServers(req.Context().Done(), filter, idx, wait)
+	writeServers(w, req, alive, cur)
+}
+
+func (r *GeeRegistry) HandleHTTP(registryPath string) {
+	http.Handle(registryPath, r)
+	r.log().Log(geerpc.LevelInfo, "rpc registry: serving", geerpc.F("path", registryPath))
+}
+
+//设置注册中心的日志，应在开始服务前调用
+func (r *GeeRegistry) SetLogger(l geerpc.Logger) {
+	r.logger = l
 }
 
-func sendHeartbeat(registry, addr string) error {
-	log.Println(addr, "send heart beat to registry", registry)
-	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", registry, nil)
-	req.Header.Set("X-Geerpc-Server", addr)
-	if _, err := httpClient.Do(req); err != nil {
-		log.Println("rpc server: heart breat err:", err)
-		return err
+func (r *GeeRegistry) log() geerpc.Logger {
+	if r.logger == nil {
+		return geerpc.DefaultLogger
 	}
-	return nil
-}
\ No newline at end of file
+	return r.logger
+}
+
+func HandleHTTP() {
+	DefaultGeeRegister.HandleHTTP(defaultPath)
+}
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"encoding/json"
+	"geerpc"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGeeRegistry_Watch(t *testing.T) {
+	r := New(time.Millisecond * 200)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	get := func(query string) (string, uint64) {
+		resp, err := http.Get(ts.URL + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		index, _ := strconv.ParseUint(resp.Header.Get("X-Geerpc-Index"), 10, 64)
+		return resp.Header.Get("X-Geerpc-Servers"), index
+	}
+	servers, index := get("")
+	if servers != "" || index == 0 {
+		t.Fatalf("unexpected initial state: %q %d", servers, index)
+	}
+
+	t.Run("wake on register", func(t *testing.T) {
+		go func() {
+			time.Sleep(time.Millisecond * 50)
+			_ = sendHeartbeat(ts.URL, ServerItem{Addr: "tcp@a"}, nil, geerpc.NopLogger)
+		}()
+		servers, next := get("?wait=5s&index=" + strconv.FormatUint(index, 10))
+		if servers != "tcp@a" || next == index {
+			t.Fatalf("expect tcp@a with a new index, got %q %d", servers, next)
+		}
+		index = next
+	})
+	t.Run("wake on expire", func(t *testing.T) {
+		start := time.Now()
+		servers, next := get("?wait=5s&index=" + strconv.FormatUint(index, 10))
+		if servers != "" || next == index || time.Since(start) > time.Second*2 {
+			t.Fatalf("expect tcp@a to expire, got %q %d", servers, next)
+		}
+		index = next
+	})
+	t.Run("wait timeout", func(t *testing.T) {
+		servers, next := get("?wait=50ms&index=" + strconv.FormatUint(index, 10))
+		if servers != "" || next != index {
+			t.Fatalf("expect nothing changed, got %q %d", servers, next)
+		}
+	})
+}
+
+func TestGeeRegistry_Metadata(t *testing.T) {
+	r := New(0)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	item := ServerItem{Addr: "tcp@a", Version: "v2", Weight: 3, Zone: "a", Tags: []string{"canary"}}
+	if err := sendHeartbeat(ts.URL, item, func() []string { return []string{"Foo"} }, geerpc.NopLogger); err != nil {
+		t.Fatal(err)
+	}
+	//旧格式的心跳只有请求头
+	req, _ := http.NewRequest("POST", ts.URL, nil)
+	req.Header.Set("X-Geerpc-Server", "tcp@b")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("legacy heartbeat failed: %v", err)
+	}
+
+	resp, err := http.Get(ts.URL + "?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var list ServerList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("X-Geerpc-Servers") != "tcp@a,tcp@b" || len(list.Servers) != 2 {
+		t.Fatalf("unexpected servers: %q %+v", resp.Header.Get("X-Geerpc-Servers"), list)
+	}
+	got := list.Servers[0]
+	if got.Version != "v2" || got.Weight != 3 || got.Zone != "a" || len(got.Tags) != 1 ||
+		len(got.Services) != 1 || got.Updated.IsZero() {
+		t.Fatalf("metadata lost: %+v", got)
+	}
+}
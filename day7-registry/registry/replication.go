@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"geerpc"
+	"net/http"
+	"time"
+)
+
+//注册中心之间互相转发的请求带有这个头，收到后不再继续转发
+const replicatedHeader = "X-Geerpc-Replicated"
+
+var replicateClient = &http.Client{Timeout: time.Second * 5}
+
+//设置其他注册中心的地址。心跳和注销会转发给所有 peer；
+//interval 不为 0 时还会定期把完整的列表推送给 peer（反熵），
+//补上转发时丢失的更新，比如 peer 重启或者网络中断。
+func (r *GeeRegistry) SetPeers(peers []string, interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers = peers
+	if r.stopSync != nil {
+		close(r.stopSync)
+		r.stopSync = nil
+	}
+	if interval > 0 && len(peers) > 0 {
+		r.stopSync = make(chan struct{})
+		go r.syncLoop(interval, r.stopSync)
+	}
+}
+
+
+//把服务器发来的心跳或注销转发给所有 peer
+func (r *GeeRegistry) replicate(req *http.Request, item ServerItem) {
+	if req.Header.Get(replicatedHeader) != "" {
+		return
+	}
+	r.mu.Lock()
+	peers := r.peers
+	r.mu.Unlock()
+	body, _ := json.Marshal(item)
+	for _, peer := range peers {
+		go func(peer string) {
+			req, _ := http.NewRequest(req.Method, peer, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(replicatedHeader, "1")
+			if err := sendToPeer(req); err != nil {
+				r.log().Log(geerpc.LevelWarn, "rpc registry: replicate failed", geerpc.F(geerpc.FieldPeer, peer), geerpc.F(geerpc.FieldError, err))
+			}
+		}(peer)
+	}
+}
+
+func (r *GeeRegistry) syncLoop(interval time.Duration, stop chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		r.mu.Lock()
+		peers := r.peers
+		list := ServerList{Index: r.index, Servers: r.aliveLocked(serverFilter{})}
+		r.mu.Unlock()
+		body, _ := json.Marshal(list)
+		for _, peer := range peers {
+			req, _ := http.NewRequest("PUT", peer, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(replicatedHeader, "1")
+			if err := sendToPeer(req); err != nil {
+				r.log().Log(geerpc.LevelWarn, "rpc registry: sync failed", geerpc.F(geerpc.FieldPeer, peer), geerpc.F(geerpc.FieldError, err))
+			}
+		}
+	}
+}
+
+func sendToPeer(req *http.Request) error {
+	return doRequest(replicateClient, req)
+}
+
+//合并 peer 推送过来的列表，心跳时间更新的一方为准。
+//注销之后没有再发心跳的服务不会被合并回来。
+func (r *GeeRegistry) merge(items []ServerItem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ttl := r.timeout
+	if ttl == 0 {
+		ttl = defaultTimeout
+	}
+	for addr, at := range r.removed {
+		if at.Add(ttl).Before(time.Now()) {
+			delete(r.removed, addr)
+		}
+	}
+	changed := false
+	for _, item := range items {
+		if at, ok := r.removed[item.Addr]; ok && !item.Updated.After(at) {
+			continue
+		}
+		if r.timeout != 0 && item.Updated.Add(r.timeout).Before(time.Now()) {
+			continue
+		}
+		s := r.servers[item.Addr]
+		if s != nil && !item.Updated.After(s.Updated) {
+			continue
+		}
+		if s == nil || !s.sameMeta(&item) {
+			changed = true
+		}
+		delete(r.removed, item.Addr)
+		item := item
+		r.servers[item.Addr] = &item
+	}
+	if changed {
+		r.bump()
+	}
+}
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"geerpc"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//等待 cond 成立，超时失败
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatal("condition not met in time")
+}
+
+func TestGeeRegistry_Replication(t *testing.T) {
+	var regs []*GeeRegistry
+	var urls []string
+	for i := 0; i < 3; i++ {
+		r := New(0)
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+		defer r.Close()
+		regs = append(regs, r)
+		urls = append(urls, ts.URL)
+	}
+	for i, r := range regs {
+		var peers []string
+		for j, u := range urls {
+			if j != i {
+				peers = append(peers, u)
+			}
+		}
+		r.SetPeers(peers, 0)
+	}
+	has := func(r *GeeRegistry, addr string) bool {
+		for _, s := range r.aliveServers() {
+			if s.Addr == addr {
+				return true
+			}
+		}
+		return false
+	}
+
+	//心跳发给第一个注册中心，复制到所有注册中心
+	h := Heartbeat(urls[0], "tcp@a", time.Hour)
+	waitFor(t, func() bool { return has(regs[1], "tcp@a") && has(regs[2], "tcp@a") })
+	//从第二个注册中心注销，所有注册中心都删除
+	h.Stop()
+	if err := sendDeregister(urls[1], "tcp@a", geerpc.NopLogger); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !has(regs[0], "tcp@a") && !has(regs[2], "tcp@a") })
+
+	t.Run("failover", func(t *testing.T) {
+		h := Heartbeat("http://127.0.0.1:1,"+urls[2], "tcp@b", time.Hour)
+		defer h.Stop()
+		waitFor(t, func() bool { return has(regs[0], "tcp@b") && has(regs[2], "tcp@b") })
+	})
+}
+
+func TestGeeRegistry_AntiEntropy(t *testing.T) {
+	a, b := New(0), New(0)
+	tsA, tsB := httptest.NewServer(a), httptest.NewServer(b)
+	defer tsA.Close()
+	defer tsB.Close()
+
+	//加入 peer 之前已有的服务也会通过定期同步复制过去
+	a.putServer(ServerItem{Addr: "tcp@a"})
+	a.putServer(ServerItem{Addr: "tcp@gone"})
+	//b 上已经注销过的服务不会被旧数据复制回来
+	b.removeServer("tcp@gone")
+	a.SetPeers([]string{tsB.URL}, time.Millisecond*20)
+	defer a.Close()
+	waitFor(t, func() bool { return len(b.aliveServers()) == 1 })
+	time.Sleep(time.Millisecond * 60)
+	if servers := strings.Join(addrs(b.aliveServers()), ","); servers != "tcp@a" {
+		t.Fatalf("expect only tcp@a on b, got %s", servers)
+	}
+}
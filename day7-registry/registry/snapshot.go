@@ -0,0 +1,125 @@
+package registry
+
+import (
+	"encoding/json"
+	"geerpc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//开启快照：先从 path 恢复服务列表，之后每隔 interval 把服务列表写入 path，
+//Close 时再写一次。恢复的服务标记为 Unconfirmed，收到心跳后恢复正常，
+//在超时时间内没有心跳则和普通服务一样被删除。
+func (r *GeeRegistry) EnableSnapshot(path string, interval time.Duration) error {
+	if err := r.restore(path); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshotPath = path
+	if interval > 0 && r.stopSnapshot == nil {
+		r.stopSnapshot = make(chan struct{})
+		r.snapshotDone = make(chan struct{})
+		go r.snapshotLoop(path, interval, r.stopSnapshot, r.snapshotDone)
+	}
+	return nil
+}
+
+//停止复制和定期快照，并写入最后一次快照
+func (r *GeeRegistry) Close() {
+	r.SetPeers(nil, 0)
+	r.mu.Lock()
+	stop, done, path := r.stopSnapshot, r.snapshotDone, r.snapshotPath
+	r.stopSnapshot, r.snapshotDone = nil, nil
+	r.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	if path != "" {
+		if err := r.Snapshot(path); err != nil {
+			r.log().Log(geerpc.LevelWarn, "rpc registry: snapshot failed", geerpc.F("path", path), geerpc.F(geerpc.FieldError, err))
+		}
+	}
+}
+
+func (r *GeeRegistry) snapshotLoop(path string, interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		if err := r.Snapshot(path); err != nil {
+			r.log().Log(geerpc.LevelWarn, "rpc registry: snapshot failed", geerpc.F("path", path), geerpc.F(geerpc.FieldError, err))
+		}
+	}
+}
+
+//把当前的服务列表写入 path，先写临时文件再改名，不会留下写了一半的文件
+func (r *GeeRegistry) Snapshot(path string) error {
+	r.mu.Lock()
+	list := ServerList{Index: r.index, Servers: r.aliveLocked(serverFilter{})}
+	r.mu.Unlock()
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+//从 path 恢复服务列表，文件不存在时什么也不做
+func (r *GeeRegistry) restore(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list ServerList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, item := range list.Servers {
+		if _, ok := r.servers[item.Addr]; ok {
+			continue
+		}
+		//重新给一个完整的超时周期等待心跳
+		item := item
+		item.Updated = now
+		item.Unconfirmed = true
+		r.servers[item.Addr] = &item
+	}
+	//版本号继续增长，watch 中的客户端会立即拿到新列表
+	if list.Index >= r.index {
+		r.index = list.Index
+	}
+	r.bump()
+	r.log().Log(geerpc.LevelInfo, "rpc registry: restored", geerpc.F("servers", len(list.Servers)), geerpc.F("path", path))
+	return nil
+}
@@ -0,0 +1,45 @@
+package registry
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGeeRegistry_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	r := New(time.Minute)
+	if err := r.EnableSnapshot(path, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	r.putServer(ServerItem{Addr: "tcp@a", Zone: "a"})
+	r.putServer(ServerItem{Addr: "tcp@b"})
+	r.Close()
+
+	//重启后立即恢复出服务列表，但标记为未确认
+	restarted := New(time.Minute)
+	if err := restarted.EnableSnapshot(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	servers := restarted.aliveServers()
+	if len(servers) != 2 || servers[0].Zone != "a" || !servers[0].Unconfirmed || !servers[1].Unconfirmed {
+		t.Fatalf("unexpected restored servers: %+v", servers)
+	}
+	//收到心跳后确认
+	restarted.putServer(ServerItem{Addr: "tcp@a", Zone: "a"})
+	servers = restarted.aliveServers()
+	if servers[0].Unconfirmed || !servers[1].Unconfirmed {
+		t.Fatalf("expect only tcp@a confirmed: %+v", servers)
+	}
+}
+
+func TestGeeRegistry_SnapshotMissingFile(t *testing.T) {
+	r := New(0)
+	if err := r.EnableSnapshot(filepath.Join(t.TempDir(), "none.json"), 0); err != nil {
+		t.Fatalf("expect a missing snapshot to be ignored: %v", err)
+	}
+	if len(r.aliveServers()) != 0 {
+		t.Fatal("expect no servers")
+	}
+}
@@ -1,15 +1,19 @@
 package geerpc
 
 import (
+	"bytes"
+	"context"
 	"geerpc/codec"
+	"geerpc/tracing"
 	"io"
 	"net"
-	"log"
 	"sync"
 	"reflect"
 	"encoding/json"
 	"errors"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 	"fmt"
 	"net/http"
@@ -23,6 +27,11 @@ type Option struct {
 
 	ConnectTimeout	time.Duration
 	HandleTimeout	time.Duration
+
+	//客户端的追踪，不发送给服务端
+	Tracer			tracing.Tracer	`json:"-"`
+	//客户端的日志，为空时使用 DefaultLogger
+	Logger			Logger			`json:"-"`
 }
 //默认格式
 var DefaultOption = &Option {
@@ -37,20 +46,222 @@ var DefaultOption = &Option {
 //线程安全map
 type Server struct {
 	serviceMap sync.Map
+
+	//优雅关闭相关
+	mu			sync.Mutex
+	listeners	map[net.Listener]struct{}	//Accept 中的监听器
+	conns		map[codec.Codec]*connInfo	//正在服务的连接
+	onShutdown	[]func()					//Shutdown 时调用，比如从注册中心注销
+	inShutdown	int32						//原子操作，1 表示正在关闭
+	active		int64						//原子操作，正在处理的请求数
+
+	metrics		*serverMetrics				//用 getMetrics 访问，第一次使用时创建
+	metricsOnce	sync.Once
+	tracer		tracing.Tracer
+
+	//调试页面，见 debug.go
+	start		time.Time
+	inflight	sync.Map					//正在执行的请求，*request -> struct{}
+
+	logger		Logger
+	accessLogOpt	atomic.Value		//访问日志的 *AccessLogOption，见 accesslog.go
+	strict		bool						//有被跳过的方法时注册失败，由 mu 保护
+	batchSvc	*service					//内置的批量服务，见 batch.go
+	batchMaxItems		int				//由 mu 保护，见 SetBatchLimits
+	batchConcurrency	int
 }
 //注册方法，传入一个reciver
 func (server *Server) Register (rcvr interface{}) error {
-	s := newService(rcvr)
+	return server.RegisterName("", rcvr)
+}
+
+//用指定的服务名注册，name 为空时使用类型名
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	_, err := server.RegisterWithReport(name, rcvr)
+	return err
+}
+
+//同 RegisterName，同时返回每个导出方法的检查结果。
+//没有可用方法的服务总是注册失败，严格模式下有被跳过的方法也注册失败。
+func (server *Server) RegisterWithReport(name string, rcvr interface{}) (*RegisterReport, error) {
+	s, report, err := server.newService(name, rcvr)
+	if err != nil {
+		return report, err
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
-		return errors.New("rpc: service already defined: " + s.name)
+		return report, errors.New("rpc: service already defined: " + s.name)
+	}
+	server.logMethods(s)
+	return report, nil
+}
+
+//严格模式：服务有签名不符合条件的导出方法时注册失败，而不是跳过这些方法
+func (server *Server) SetStrict(strict bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.strict = strict
+}
+
+//把函数注册为 Service.Method，签名必须是 func([ctx context.Context,] args T, reply *R) error，
+//ctx 带有调用方的元数据（见 IncomingMetadata），超时后被取消。
+//同一个服务名可以注册多个函数，但不能和 Register 注册的服务同名。
+func (server *Server) RegisterFunc(serviceMethod string, fn interface{}) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot <= 0 || dot == len(serviceMethod)-1 || strings.ContainsAny(serviceMethod, " \t\r\n") {
+		err := fmt.Errorf("rpc server: %q is not a valid Service.Method", serviceMethod)
+		server.log().Log(LevelError, "rpc server: register failed", F(FieldError, err))
+		return err
+	}
+	name, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	m, err := newFuncMethod(serviceMethod, fn)
+	if err == nil {
+		err = checkServiceName(name)
+	}
+	if err != nil {
+		server.log().Log(LevelError, "rpc server: register failed", F(FieldError, err))
+		return err
+	}
+	m.method.Name = methodName
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	//写时复制，正在执行的调用仍然使用旧的服务
+	s := &service{name: name, method: map[string]*methodType{methodName: m}}
+	if old, ok := server.serviceMap.Load(name); ok {
+		old := old.(*service)
+		if old.rcvr.IsValid() {
+			return errors.New("rpc: service already defined: " + name)
+		}
+		if old.method[methodName] != nil {
+			return errors.New("rpc: method already defined: " + serviceMethod)
+		}
+		for k, v := range old.method {
+			s.method[k] = v
+		}
+	}
+	server.serviceMap.Store(name, s)
+	server.log().Log(LevelDebug, "rpc server: register", F(FieldMethod, serviceMethod))
+	return nil
+}
+
+//注销服务，正在执行的调用不受影响
+func (server *Server) Unregister(name string) error {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, ok := server.serviceMap.Load(name); !ok {
+		return errors.New("rpc: service not defined: " + name)
+	}
+	server.serviceMap.Delete(name)
+	server.log().Log(LevelDebug, "rpc server: unregister", F("service", name))
+	return nil
+}
+
+//原子地把已注册的服务换成新的接收器，之后的调用使用 rcvr，
+//正在执行的调用仍然使用旧的接收器直到结束
+func (server *Server) Replace(name string, rcvr interface{}) error {
+	s, _, err := server.newService(name, rcvr)
+	if err != nil {
+		return err
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if _, ok := server.serviceMap.Load(s.name); !ok {
+		return errors.New("rpc: service not defined: " + s.name)
+	}
+	server.serviceMap.Store(s.name, s)
+	server.logMethods(s)
+	return nil
+}
+
+func (server *Server) newService(name string, rcvr interface{}) (*service, *RegisterReport, error) {
+	s, err := newNamedService(rcvr, name)
+	if err == nil {
+		err = checkServiceName(s.name)
+	}
+	var report *RegisterReport
+	if err == nil {
+		report = s.report()
+		err = server.checkReport(report)
+	}
+	if err != nil {
+		server.log().Log(LevelError, "rpc server: register failed", F(FieldError, err))
+		return nil, report, err
+	}
+	for _, m := range report.Rejected {
+		server.log().Log(LevelWarn, "rpc server: method skipped", F(FieldMethod, s.name+"."+m.Name), F("reason", m.Reason))
+	}
+	return s, report, nil
+}
+
+//geerpc 留给内置服务使用，findService 不会查找同名的服务
+func checkServiceName(name string) error {
+	if name == batchServiceName {
+		return fmt.Errorf("rpc server: service name %q is reserved", name)
 	}
 	return nil
 }
 
+func (server *Server) checkReport(report *RegisterReport) error {
+	if len(report.Methods) == 0 {
+		return fmt.Errorf("rpc server: service has no suitable methods: %s", report)
+	}
+	server.mu.Lock()
+	strict := server.strict
+	server.mu.Unlock()
+	if strict && len(report.Rejected) > 0 {
+		return fmt.Errorf("rpc server: service has unsuitable methods: %s", report)
+	}
+	return nil
+}
+
+func (server *Server) logMethods(s *service) {
+	for name := range s.method {
+		server.log().Log(LevelDebug, "rpc server: register", F(FieldMethod, s.name+"."+name))
+	}
+}
+
+//设置服务器的日志，需在开始服务之前设置，为空时使用 DefaultLogger
+func (server *Server) SetLogger(logger Logger) {
+	server.logger = logger
+}
+
+//服务器使用的日志
+func (server *Server) Logger() Logger {
+	return server.log()
+}
+
+func (server *Server) log() Logger {
+	return loggerOr(server.logger)
+}
+
 func Register (rcvr interface{}) error {
 	return DefaultServer.Register(rcvr)
 }
 
+func RegisterName(name string, rcvr interface{}) error {
+	return DefaultServer.RegisterName(name, rcvr)
+}
+
+func RegisterFunc(serviceMethod string, fn interface{}) error {
+	return DefaultServer.RegisterFunc(serviceMethod, fn)
+}
+
+func Unregister(name string) error {
+	return DefaultServer.Unregister(name)
+}
+
+//已注册的服务名，按名称排序
+func (server *Server) Services() []string {
+	var names []string
+	server.serviceMap.Range(func(namei, _ interface{}) bool {
+		names = append(names, namei.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
 func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
@@ -58,6 +269,13 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	if serviceName == batchServiceName && server.batchSvc != nil {
+		svc = server.batchSvc
+		if mtype = svc.method[methodName]; mtype == nil {
+			err = errors.New("rpc server: can't find method " + methodName)
+		}
+		return
+	}
 	//查找到service
 	svci, ok := server.serviceMap.Load(serviceName)
 	if !ok {
@@ -76,17 +294,29 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 //day3----------
 
 func NewServer() *Server {
-	return &Server{}
+	server := &Server{
+		start:		time.Now(),
+	}
+	server.batchSvc = server.newBatchService()
+	return server
 }
 
 var DefaultServer = NewServer()
 
 //server方法,与监听。
 func (server *Server) Accept(lis net.Listener) {
+	if !server.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer server.trackListener(lis, false)
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			//关闭时监听器被主动关掉，不算错误
+			if !server.shuttingDown() {
+				server.log().Log(LevelError, "rpc server: accept error", F(FieldError, err))
+			}
 			return
 		}
 		go server.ServeConn(conn)
@@ -100,53 +330,113 @@ func Accept(lis net.Listener) {
 
 //连接器，验证这个连接是否为合法连接
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
+	//对端地址，用于追踪
+	var peer string
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		peer = c.RemoteAddr().String()
+	}
+	conn = server.getMetrics().countConn(conn)
 	defer func() {
 		_ = conn.Close()
 	}()
 
 	//解析Option,验证是否为合理请求
 	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options error: ", err)
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
+		server.log().Log(LevelWarn, "rpc server: options error", F(FieldPeer, peer), F(FieldError, err))
 		return
 	}
 	if opt.MagicNumber != MagicNumber {
-		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
+		server.log().Log(LevelWarn, fmt.Sprintf("rpc server: invalid magic number %x", opt.MagicNumber), F(FieldPeer, peer))
 		return
 	}
 
 	//获取解码器
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f==nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+		server.log().Log(LevelWarn, "rpc server: invalid codec type", F("codec", opt.CodecType), F(FieldPeer, peer))
 		return
 	}
 
 	//合理请求则继续解码，f() 是上面解码器函数。
-	server.serveCodec(f(conn), &opt)
+	r, err := optionReader(dec, conn)
+	if err != nil {
+		server.log().Log(LevelWarn, "rpc server: options error", F(FieldPeer, peer), F(FieldError, err))
+		return
+	}
+	info := &connInfo{peer: peer, codec: opt.CodecType}
+	rwc := newSizeConn(&optionConn{r: r, ReadWriteCloser: conn}, info)
+	server.serveCodec(f(rwc), &opt, info)
+}
+
+//json.Encoder 在 Option 后面写入一个换行符，有的话正好跳过这一个字节，
+//没有换行符的客户端也能连接。json解码器可能已经多读了后面的请求，要拼回去。
+func optionReader(dec *json.Decoder, conn io.Reader) (io.Reader, error) {
+	r := io.MultiReader(dec.Buffered(), conn)
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+	if b[0] != '\n' {
+		return io.MultiReader(bytes.NewReader(b[:]), r), nil
+	}
+	return r, nil
+}
+
+//先读json解码器缓冲的数据，再读连接
+type optionConn struct {
+	r	io.Reader
+	io.ReadWriteCloser
+}
+
+func (c *optionConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
 }
 
 //解码器
 var invalidRequest = struct{}{}
 
-func (server *Server) serveCodec (cc codec.Codec, opt *Option) {
-	//互斥发送锁和等待队列信号量
-	sending := new(sync.Mutex)
+//conn 的 sending 是互斥发送锁
+func (server *Server) serveCodec (cc codec.Codec, opt *Option, conn *connInfo) {
+	conn.start = time.Now()
+	if !server.trackConn(cc, conn) {
+		_ = cc.Close()
+		return
+	}
+	defer server.trackConn(cc, nil)
+	//等待队列信号量
 	wg := new(sync.WaitGroup)
 	for {
 		//读取请求,没有请求时，结束。
+		read := conn.read
 		req, err := server.readRequest(cc)
+		if req != nil {
+			req.conn = conn
+			req.size = conn.read - read
+		}
 		if err != nil {
 			if req == nil {
 				break
 			}
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
+			code := codeBadRequest
+			if req.mtype == nil {
+				code = codeNotFound
+			}
+			server.reject(cc, req, code, err)
+			continue
+		}
+		//先计数再检查是否在关闭，保证 Shutdown 不会漏掉这个请求
+		atomic.AddInt64(&server.active, 1)
+		if server.shuttingDown() {
+			atomic.AddInt64(&server.active, -1)
+			server.reject(cc, req, codeShutdown, ErrServerClosed)
 			continue
 		}
+		atomic.AddInt64(&conn.pending, 1)
 		//并发处理请求
 		wg.Add(1)
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		go server.handleRequest(cc, req, wg, opt.HandleTimeout)
 	}
 	//等待所有协程执行完毕
 	wg.Wait()
@@ -163,6 +453,9 @@ type request struct {
 	argv, replyv	reflect.Value
 	mtype			*methodType
 	svc				*service
+	conn			*connInfo	//所在的连接
+	start			time.Time	//开始执行的时间
+	size			int64		//请求的字节数，包括header
 }
 
 //读取请求，传入解码器，返回解析的请求
@@ -174,6 +467,8 @@ func (server *Server) readRequest(cc codec.Codec ) (*request, error) {
 	req := &request{h: h}
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
 	if err != nil {
+		//丢弃请求体，否则会被当成下一个请求头
+		_ = cc.ReadBody(nil)
 		return req, err
 	}
 	req.argv = req.mtype.newArgv()
@@ -185,7 +480,7 @@ func (server *Server) readRequest(cc codec.Codec ) (*request, error) {
 		argvi = req.argv.Addr().Interface()
 	}
 	if err = cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read body err:", err)
+		server.log().Log(LevelWarn, "rpc server: read body error", F(FieldMethod, h.ServiceMethod), F(FieldSeq, h.Seq), F(FieldError, err))
 		return req, err
 	}
 	return req, nil
@@ -196,7 +491,7 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	var h codec.Header
 	if err := cc.ReadHeader(&h); err != nil {
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server: read header error:", err)
+			server.log().Log(LevelWarn, "rpc server: read header error", F(FieldError, err))
 		}
 		return nil,err
 	}
@@ -205,28 +500,75 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 
 
 
-func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
-	sending.Lock()
-	defer sending.Unlock()
+//返回响应的字节数
+func (server *Server) sendResponse(cc codec.Codec, conn *connInfo, h *codec.Header, body interface{}) int64 {
+	conn.sending.Lock()
+	defer conn.sending.Unlock()
+	written := conn.written
 	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server: write response error:", err)
+		server.log().Log(LevelWarn, "rpc server: write response error", F(FieldMethod, h.ServiceMethod), F(FieldSeq, h.Seq), F(FieldError, err))
 	}
+	return conn.written - written
 }
 
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+//没有进入 handleRequest 的失败请求，直接返回错误
+func (server *Server) reject(cc codec.Codec, req *request, code string, err error) {
+	server.getMetrics().reject(req.h.ServiceMethod, code)
+	req.h.Error = err.Error()
+	size := server.sendResponse(cc, req.conn, req.h, invalidRequest)
+	server.accessLog(req, 0, code, size)
+}
+
+func (server *Server) handleRequest(cc codec.Codec, req *request, wg *sync.WaitGroup, timeout time.Duration) {
 	defer wg.Done()
+	defer atomic.AddInt64(&server.active, -1)
+	defer atomic.AddInt64(&req.conn.pending, -1)
 	called	 := make(chan struct{})
 	sent	 := make(chan struct{})
+	//超时和正常结束只统计先发生的一个
+	start := time.Now()
+	req.start = start
+	//超时或者请求结束后取消，传给 RegisterFunc 注册的函数
+	ctx, cancel := context.WithCancel(withIncomingMetadata(context.Background(), req.h.Metadata))
+	defer cancel()
+	ctx, span := server.startSpan(ctx, req)
+	var once sync.Once
+	//只有第一次调用返回 true，由它发送的响应写入访问日志
+	done := func(code string, err error) (first bool) {
+		once.Do(func() {
+			first = true
+			server.getMetrics().done(req, start, code)
+			req.mtype.record(time.Since(start), code != "")
+			if span != nil {
+				endSpan(span, req.h.Seq, err)
+			}
+		})
+		return
+	}
 	go func() {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
+		inFlight := server.getMetrics().inFlight.With(req.svc.name, req.mtype.method.Name)
+		inFlight.Inc()
+		server.trackRequest(req, true)
+		err := req.svc.call(ctx, req.mtype, req.argv, req.replyv)
+		server.trackRequest(req, false)
+		inFlight.Dec()
+		if pe, ok := err.(*panicError); ok {
+			server.log().Log(LevelError, pe.Error(), F(FieldMethod, req.h.ServiceMethod), F(FieldSeq, req.h.Seq), F(FieldPeer, req.conn.peer), F("stack", string(pe.stack)))
+		}
+		code := errorCode(err)
+		elapsed := time.Since(start)
+		first := done(code, err)
 		called <- struct{}{}
+		var size int64
 		if err != nil {
 			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
-			sent <- struct{}{}
-			return
+			size = server.sendResponse(cc, req.conn, req.h, invalidRequest)
+		} else {
+			size = server.sendResponse(cc, req.conn, req.h, req.replyv.Interface())
+		}
+		if first {
+			server.accessLog(req, elapsed, code, size)
 		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
 		sent <- struct{}{}
 	}()
 	
@@ -238,7 +580,12 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 	select {
 	case <-time.After(timeout):
 		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		server.sendResponse(cc, req.h, invalidRequest, sending)
+		first := done(codeTimeout, errors.New(req.h.Error))
+		server.log().Log(LevelWarn, "rpc server: request handle timeout", F(FieldMethod, req.h.ServiceMethod), F(FieldSeq, req.h.Seq), F(FieldPeer, req.conn.peer))
+		size := server.sendResponse(cc, req.conn, req.h, invalidRequest)
+		if first {
+			server.accessLog(req, timeout, codeTimeout, size)
+		}
 	case <-called:
 		<-sent
 	}
@@ -249,6 +596,7 @@ const (
 	connected			=	"200 Connected to Gee RPC"
 	defaultRPCPath		=	"/_geeroc_"
 	defaultDebugPath	=	"/debug/geerpc"
+	defaultMetricsPath	=	"/debug/geerpc/metrics"
 )
 
 func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -260,7 +608,7 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		server.log().Log(LevelError, "rpc server: hijacking error", F(FieldPeer, req.RemoteAddr), F(FieldError, err))
 		return
 	}
 	_, _ = io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
@@ -276,5 +624,7 @@ func HandleHTTP() {
 func (server *Server) HandleHTTP() {
 	http.Handle(defaultRPCPath, server)
 	http.Handle(defaultDebugPath, debugHTTP{server})
-	log.Println("rpc server debug path:", defaultDebugPath)
+	http.Handle(defaultMetricsPath, server.getMetrics().registry)
+	server.log().Log(LevelInfo, "rpc server debug path", F("path", defaultDebugPath))
+	server.log().Log(LevelInfo, "rpc server metrics path", F("path", defaultMetricsPath))
 }
\ No newline at end of file
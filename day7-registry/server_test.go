@@ -0,0 +1,193 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"geerpc/codec"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+//在随机端口上启动 server 并建立一个连接，测试结束时关闭
+func serveAndDial(t *testing.T, server *Server) *Client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() { _ = l.Close() })
+	client, err := Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+//按租户注册的服务，返回自己的名字
+type Tenant string
+
+func (t Tenant) Name(ms int, reply *string) error {
+	time.Sleep(time.Millisecond * time.Duration(ms))
+	*reply = string(t)
+	return nil
+}
+
+func TestServer_RegisterName(t *testing.T) {
+	server := NewServer()
+	a, b := Tenant("a"), Tenant("b")
+	_assert(server.RegisterName("Tenant.a", &a) == nil, "failed to register Tenant.a")
+	_assert(server.RegisterName("Tenant.b", &b) == nil, "failed to register Tenant.b")
+	_assert(server.RegisterName("Tenant.a", &b) != nil, "expect error for duplicate name")
+	_assert(server.RegisterName("bad name", &b) != nil, "expect error for invalid name")
+	client := serveAndDial(t, server)
+	var reply string
+	_assert(client.Call(context.Background(), "Tenant.b.Name", 0, &reply) == nil && reply == "b", "unexpected reply %q", reply)
+
+	//替换后新的调用使用新的接收器，正在执行的调用不受影响
+	call := client.Go("Tenant.a.Name", 200, new(string), nil)
+	time.Sleep(time.Millisecond * 50)
+	c := Tenant("c")
+	_assert(server.Replace("Tenant.a", &c) == nil, "failed to replace Tenant.a")
+	_assert(server.Replace("Tenant.x", &c) != nil, "expect error when replacing an unknown service")
+	_assert(client.Call(context.Background(), "Tenant.a.Name", 0, &reply) == nil && reply == "c", "unexpected reply %q", reply)
+
+	_assert(server.Unregister("Tenant.a") == nil, "failed to unregister Tenant.a")
+	_assert(server.Unregister("Tenant.a") != nil, "expect error when unregistering twice")
+	<-call.Done
+	_assert(call.Error == nil && *call.Reply.(*string) == "a", "expect the in-flight call to finish: %v", call.Error)
+	_assert(client.Call(context.Background(), "Tenant.a.Name", 0, &reply) != nil, "expect error after unregister")
+	_assert(len(server.Services()) == 1, "unexpected services %v", server.Services())
+}
+
+func TestServer_RegisterFunc(t *testing.T) {
+	server := NewServer()
+	offset := 10
+	_assert(server.RegisterFunc("Math.Add", func(ctx context.Context, args Args, reply *int) error {
+		*reply = args.Num1 + args.Num2 + offset
+		if IncomingMetadata(ctx)["double"] == "1" {
+			*reply *= 2
+		}
+		return nil
+	}) == nil, "failed to register Math.Add")
+	_assert(server.RegisterFunc("Math.Mul", func(args Args, reply *int) error {
+		*reply = args.Num1 * args.Num2
+		return nil
+	}) == nil, "failed to register Math.Mul")
+
+	mul := func(args Args, reply *int) error { return nil }
+	_assert(server.RegisterFunc("Math.Mul", mul) != nil, "expect error for duplicate method")
+	_assert(server.RegisterFunc("Mul", mul) != nil, "expect error for missing service name")
+	_assert(server.RegisterFunc("Math.Bad", 1) != nil, "expect error for non-function")
+	_assert(server.RegisterFunc("Math.Bad", func(args Args, reply int) error { return nil }) != nil, "expect error for non-pointer reply")
+	_assert(server.RegisterFunc("Math.Bad", func(args Args, reply *int) int { return 0 }) != nil, "expect error for non-error return")
+	_assert(server.RegisterFunc("Math.Bad", func(a, b Args, reply *int) error { return nil }) != nil, "expect error for non-context first argument")
+	var foo Foo
+	_ = server.Register(&foo)
+	_assert(server.RegisterFunc("Foo.Mul", mul) != nil, "expect error for clashing with a registered receiver")
+
+	client := serveAndDial(t, server)
+	var reply int
+	_assert(client.Call(context.Background(), "Math.Add", Args{1, 2}, &reply) == nil && reply == 13, "unexpected reply %d", reply)
+	ctx := WithMetadata(context.Background(), map[string]string{"double": "1"})
+	_assert(client.Call(ctx, "Math.Add", Args{1, 2}, &reply) == nil && reply == 26, "unexpected reply %d", reply)
+	_assert(client.Call(context.Background(), "Math.Mul", Args{3, 4}, &reply) == nil && reply == 12, "unexpected reply %d", reply)
+}
+
+//一个方法可用，其余签名都不对
+type Mixed int
+
+func (m Mixed) Good(args Args, reply *int) error { return nil }
+func (m Mixed) NoReply(args Args) error          { return nil }
+func (m Mixed) NoError(args Args, reply *int)    {}
+func (m Mixed) ValueReply(args Args, reply int) error {
+	return nil
+}
+
+//没有可用的方法
+type Empty int
+
+func (e Empty) Bad(args Args) error { return nil }
+
+func TestServer_RegisterWithReport(t *testing.T) {
+	server := NewServer()
+	var m Mixed
+	report, err := server.RegisterWithReport("", &m)
+	_assert(err == nil, "failed to register Mixed: %v", err)
+	_assert(len(report.Methods) == 1 && report.Methods[0] == "Good", "unexpected methods %v", report.Methods)
+	_assert(len(report.Rejected) == 3, "expect 3 rejected methods, got %v", report.Rejected)
+	reasons := make(map[string]string)
+	for _, r := range report.Rejected {
+		reasons[r.Name] = r.Reason
+	}
+	_assert(strings.Contains(reasons["ValueReply"], "not a pointer"), "unexpected reason %q", reasons["ValueReply"])
+	_assert(strings.Contains(reasons["NoError"], "error result"), "unexpected reason %q", reasons["NoError"])
+
+	var e Empty
+	_, err = server.RegisterWithReport("", &e)
+	_assert(err != nil && strings.Contains(err.Error(), "Bad"), "expect error for a service without methods, got %v", err)
+
+	strict := NewServer()
+	strict.SetStrict(true)
+	_, err = strict.RegisterWithReport("", &m)
+	_assert(err != nil && strings.Contains(err.Error(), "ValueReply"), "expect error in strict mode, got %v", err)
+	var foo Foo
+	_assert(strict.Register(&foo) == nil, "failed to register Foo in strict mode")
+
+	//注册的同时切换严格模式，配合 -race 检查
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		strict.SetStrict(false)
+	}()
+	_ = strict.RegisterName("Mixed.b", &m)
+	<-done
+}
+
+func TestOptionReader(t *testing.T) {
+	read := func(conn io.Reader) (string, error) {
+		var opt Option
+		dec := json.NewDecoder(conn)
+		_assert(dec.Decode(&opt) == nil, "failed to decode option")
+		r, err := optionReader(dec, conn)
+		if err != nil {
+			return "", err
+		}
+		rest, err := ioutil.ReadAll(r)
+		return string(rest), err
+	}
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(DefaultOption)
+	opt := buf.String()
+
+	//只跳过一个换行符，后面的请求以换行符开头也要保留
+	rest, err := read(strings.NewReader(opt + "\nreq"))
+	_assert(err == nil && rest == "\nreq", "unexpected rest %q: %v", rest, err)
+	//换行符还没有被json解码器读进缓冲
+	rest, err = read(iotest.OneByteReader(strings.NewReader(opt + "\nreq")))
+	_assert(err == nil && rest == "\nreq", "unexpected rest %q: %v", rest, err)
+	//没有换行符时不跳过任何字节
+	rest, err = read(strings.NewReader(strings.TrimSuffix(opt, "\n") + "req"))
+	_assert(err == nil && rest == "req", "unexpected rest %q: %v", rest, err)
+
+	//客户端不在 Option 后面写换行符也能调用
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	conn, serverConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	b, _ := json.Marshal(DefaultOption)
+	_, err = conn.Write(b)
+	_assert(err == nil, "failed to write option: %v", err)
+	client := newClientCodec(codec.NewGobCodec(conn), DefaultOption)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply)
+	_assert(err == nil && reply == 3, "unexpected reply %d: %v", reply, err)
+}
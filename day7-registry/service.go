@@ -1,10 +1,16 @@
 package geerpc
 
 import(
+	"context"
+	"fmt"
+	"time"
 	"reflect"
+	runtimedebug "runtime/debug"
 	"sync/atomic"
-	"log"
+	"errors"
 	"go/ast"
+	"sort"
+	"strings"
 )
 
 //方法类型
@@ -13,11 +19,25 @@ type methodType struct {
 	ArgType		reflect.Type		//传入参数
 	ReplyType	reflect.Type		//回传参数
 	numCalls	uint64				//调用次数
+	numErrors	uint64				//失败次数，包括超时和 panic
+	latency		latencyWindow		//最近的耗时，用于计算分位数
+	withCtx		bool				//第一个参数是 context.Context，见 RegisterFunc
 }
 //查看被调用次数
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
+//查看失败次数
+func (m *methodType) NumErrors() uint64 {
+	return atomic.LoadUint64(&m.numErrors)
+}
+//记录一次调用的耗时和结果
+func (m *methodType) record(d time.Duration, failed bool) {
+	if failed {
+		atomic.AddUint64(&m.numErrors, 1)
+	}
+	m.latency.add(d)
+}
 //新建参数类型实例
 func (m *methodType) newArgv() reflect.Value {
 	var argv reflect.Value
@@ -47,62 +67,182 @@ func (m *methodType) newReplyv() reflect.Value {
 type service struct {
 	name	string					//映射的名称
 	typ		reflect.Type			//结构体类型
-	rcvr	reflect.Value			//实例
+	rcvr	reflect.Value			//实例，RegisterFunc 注册的函数服务没有实例
 	method  map[string]*methodType	//符合条件的方法
+	rejected	[]RejectedMethod	//签名不符合条件的导出方法
+}
+
+//被跳过的方法及原因
+type RejectedMethod struct {
+	Name	string
+	Reason	string
+}
+
+//注册服务的检查结果
+type RegisterReport struct {
+	Service		string
+	Methods		[]string			//注册的方法，按名称排序
+	Rejected	[]RejectedMethod	//被跳过的导出方法
+}
+
+func (r *RegisterReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d methods", r.Service, len(r.Methods))
+	for i, m := range r.Rejected {
+		if i == 0 {
+			b.WriteString(", rejected: ")
+		} else {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s (%s)", m.Name, m.Reason)
+	}
+	return b.String()
+}
+
+func (s *service) report() *RegisterReport {
+	r := &RegisterReport{Service: s.name, Rejected: s.rejected}
+	for name := range s.method {
+		r.Methods = append(r.Methods, name)
+	}
+	sort.Strings(r.Methods)
+	return r
 }
 //构造函数
 //rcvr是接收器，就是一个带方法的type
-func newService(rcvr interface{}) *service {
+//服务名不合法时返回错误
+func newService(rcvr interface{}) (*service, error) {
+	return newNamedService(rcvr, "")
+}
+
+//name 为空时使用类型名，类型名必须是导出的；
+//指定的名字不要求导出，可以用同一个类型注册多个实例
+func newNamedService(rcvr interface{}, name string) (*service, error) {
+	if rcvr == nil {
+		return nil, errors.New("rpc server: nil service")
+	}
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
-	//使用indirect是为了防止rcvr是指针类型，如果是指针类型，就可以转换为他具体值的实例
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
 	s.typ  = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
+	if name == "" {
+		//使用indirect是为了防止rcvr是指针类型，如果是指针类型，就可以转换为他具体值的实例
+		name = reflect.Indirect(s.rcvr).Type().Name()
+		if !ast.IsExported(name) {
+			return nil, fmt.Errorf("rpc server: %q is not a valid service name", name)
+		}
+	} else if strings.ContainsAny(name, " \t\r\n") {
+		return nil, fmt.Errorf("rpc server: %q is not a valid service name", name)
 	}
+	s.name = name
 	s.registerMethods()
-	return s
+	return s, nil
 }
-//注册方法
+//注册方法，不符合条件的导出方法记录在 rejected 中
 func (s *service) registerMethods() {
 	s.method = make(map[string]*methodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
-		//入参
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
-			continue
-		}
-		//出参是否为error
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue;
-		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		if reason := checkMethod(method.Type); reason != "" {
+			s.rejected = append(s.rejected, RejectedMethod{Name: method.Name, Reason: reason})
 			continue
 		}
 		//登记在method的map种
 		s.method[method.Name] = &methodType {
 			method 		: method,
-			ArgType		: argType,
-			ReplyType	: replyType,
+			ArgType		: method.Type.In(1),
+			ReplyType	: method.Type.In(2),
 		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
 }
 
+//方法签名不符合条件的原因，符合时为空
+func checkMethod(mType reflect.Type) string {
+	//入参，第一个是接收器
+	if mType.NumIn() != 3 {
+		return fmt.Sprintf("expect 2 arguments (args, *reply), got %d", mType.NumIn()-1)
+	}
+	//出参是否为error
+	if mType.NumOut() != 1 || mType.Out(0) != typeOfError {
+		return "expect a single error result"
+	}
+	argType, replyType := mType.In(1), mType.In(2)
+	if !isExportedOrBuiltinType(argType) {
+		return fmt.Sprintf("argument type %s is not exported", argType)
+	}
+	if replyType.Kind() != reflect.Ptr {
+		return fmt.Sprintf("reply type %s is not a pointer", replyType)
+	}
+	if !isExportedOrBuiltinType(replyType) {
+		return fmt.Sprintf("reply type %s is not exported", replyType)
+	}
+	return ""
+}
+
 func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+//检查函数的签名，必须是 func([ctx context.Context,] args T, reply *R) error
+func newFuncMethod(name string, fn interface{}) (*methodType, error) {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return nil, fmt.Errorf("rpc server: %s: %T is not a function", name, fn)
+	}
+	ft := f.Type()
+	m := &methodType{method: reflect.Method{Name: name, Type: ft, Func: f}}
+	in := ft.NumIn()
+	if in == 3 && ft.In(0) == typeOfContext {
+		m.withCtx = true
+	} else if in != 2 {
+		return nil, fmt.Errorf("rpc server: %s: expect func([context.Context,] args, *reply) error, got %s", name, ft)
+	}
+	if ft.IsVariadic() || ft.NumOut() != 1 || ft.Out(0) != typeOfError {
+		return nil, fmt.Errorf("rpc server: %s: expect func([context.Context,] args, *reply) error, got %s", name, ft)
+	}
+	m.ArgType, m.ReplyType = ft.In(in-2), ft.In(in-1)
+	if m.ReplyType.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("rpc server: %s: reply type %s is not a pointer", name, m.ReplyType)
+	}
+	if !isExportedOrBuiltinType(m.ArgType) || !isExportedOrBuiltinType(m.ReplyType) {
+		return nil, fmt.Errorf("rpc server: %s: argument types %s, %s must be exported", name, m.ArgType, m.ReplyType)
+	}
+	return m, nil
+}
+
+//服务方法 panic 时的错误
+type panicError struct {
+	serviceMethod	string
+	value			interface{}
+	stack			[]byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("rpc server: panic in %s: %v", e.serviceMethod, e.value)
+}
+
+//ctx 只传给第一个参数是 context.Context 的函数
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) (err error) {
 	//原子操作，并发安全
 	atomic.AddUint64(&m.numCalls, 1)
+	//服务方法 panic 时不能让整个服务器退出，转成错误返回给调用方
+	defer func() {
+		if r := recover(); r != nil {
+			err = &panicError{serviceMethod: s.name + "." + m.method.Name, value: r, stack: runtimedebug.Stack()}
+		}
+	}()
 	f := m.method.Func
 	//通过反射调用方法
 	//方一个reflect.Value切片，分别是s.rcvr结构体本身,argv参数,reoplyv返回参数
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	in := make([]reflect.Value, 0, 4)
+	if s.rcvr.IsValid() {
+		in = append(in, s.rcvr)
+	}
+	if m.withCtx {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	returnValues := f.Call(append(in, argv, replyv))
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
@@ -1,6 +1,7 @@
 package geerpc
 
 import(
+	"context"
 	"fmt"
 	"testing"
 	"reflect"
@@ -30,7 +31,7 @@ func _assert(condition bool, msg string, v ...interface{}) {
 //测试创建新serer
 func TestNewService(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, _ := newService(&foo)
 	//测试是否有方法满足
 	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
 	//测试是否能有Sum方法
@@ -40,12 +41,12 @@ func TestNewService(t *testing.T) {
 
 func TestMethodType_Call(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, _ := newService(&foo)
 	mType := s.method["Sum"]
 
 	argv := mType.newArgv()
 	replyv := mType.newReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyv)
+	err := s.call(context.Background(), mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4&& mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
\ No newline at end of file
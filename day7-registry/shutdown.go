@@ -0,0 +1,99 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"geerpc/codec"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+var ErrServerClosed = errors.New("rpc server: server is shutting down")
+
+//Shutdown 等待处理中的请求时的轮询间隔
+const shutdownPollInterval = time.Millisecond * 50
+
+//注册 Shutdown 时要调用的函数，比如从注册中心注销。
+//这些函数在停止接收新连接之后、等待处理中的请求之前依次调用。
+func (server *Server) RegisterOnShutdown(f func()) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.onShutdown = append(server.onShutdown, f)
+}
+
+//优雅关闭：关闭所有监听器，调用注册的关闭函数，拒绝新的请求，
+//等待处理中的请求完成后关闭所有连接。ctx 结束时不再等待，直接关闭连接并返回 ctx 的错误。
+func (server *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&server.inShutdown, 1)
+	server.mu.Lock()
+	for l := range server.listeners {
+		_ = l.Close()
+	}
+	hooks := server.onShutdown
+	server.mu.Unlock()
+	for _, f := range hooks {
+		f()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&server.active) != 0 {
+		select {
+		case <-ctx.Done():
+			server.closeConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	server.closeConns()
+	return nil
+}
+
+func (server *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&server.inShutdown) != 0
+}
+
+func (server *Server) closeConns() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for cc := range server.conns {
+		_ = cc.Close()
+	}
+}
+
+//记录或移除监听器，正在关闭时不再接受新的监听器
+func (server *Server) trackListener(l net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.listeners, l)
+		return true
+	}
+	if server.shuttingDown() {
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[l] = struct{}{}
+	return true
+}
+
+//同上，针对连接，info 为空时移除
+func (server *Server) trackConn(cc codec.Codec, info *connInfo) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if info == nil {
+		delete(server.conns, cc)
+		return true
+	}
+	if server.shuttingDown() {
+		return false
+	}
+	if server.conns == nil {
+		server.conns = make(map[codec.Codec]*connInfo)
+	}
+	server.conns[cc] = info
+	return true
+}
@@ -0,0 +1,57 @@
+package geerpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type Slow int
+
+func (s Slow) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(ms))
+	*reply = ms
+	return nil
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	server := NewServer()
+	var s Slow
+	_ = server.Register(&s)
+	client := serveAndDial(t, server)
+
+	hooked := make(chan struct{})
+	server.RegisterOnShutdown(func() { close(hooked) })
+
+	var reply int
+	call := client.Go("Slow.Sleep", 200, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	_assert(err == nil, "shutdown failed: %v", err)
+	<-hooked
+	<-call.Done
+	_assert(call.Error == nil && reply == 200, "expect in-flight call to finish, got %v", call.Error)
+
+	_, err = Dial("tcp", client.addr)
+	_assert(err != nil, "expect listener closed after shutdown")
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	server := NewServer()
+	var s Slow
+	_ = server.Register(&s)
+	client := serveAndDial(t, server)
+	var reply int
+	call := client.Go("Slow.Sleep", 1000, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	_assert(err == context.DeadlineExceeded, "expect deadline exceeded, got %v", err)
+	<-call.Done
+	_assert(call.Error != nil, "expect the call to fail once connections are closed")
+}
@@ -0,0 +1,48 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/tracing"
+)
+
+//Option.Tracer 不为空时开始客户端 span，并把 traceparent 放进请求的元数据
+func (client *Client) startSpan(ctx context.Context, serviceMethod string) (context.Context, tracing.Span) {
+	if client.opt.Tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := client.opt.Tracer.Start(ctx, serviceMethod, tracing.SpanKindClient)
+	span.SetAttribute(tracing.AttrMethod, serviceMethod)
+	if client.addr != "" {
+		span.SetAttribute(tracing.AttrPeer, client.addr)
+	}
+	ctx = WithMetadata(ctx, map[string]string{tracing.TraceParentKey: span.Context().TraceParent()})
+	return ctx, span
+}
+
+func endSpan(span tracing.Span, seq uint64, err error) {
+	span.SetAttribute(tracing.AttrSeq, seq)
+	span.SetError(err)
+	span.End()
+}
+
+//设置服务端的追踪，需在开始服务之前设置
+func (server *Server) SetTracer(tracer tracing.Tracer) {
+	server.tracer = tracer
+}
+
+//请求带有 traceparent 时接着调用方的追踪，否则开始新的追踪
+//返回的 ctx 带有新的 span
+func (server *Server) startSpan(ctx context.Context, req *request) (context.Context, tracing.Span) {
+	if server.tracer == nil {
+		return ctx, nil
+	}
+	if sc, err := tracing.ParseTraceParent(req.h.Metadata[tracing.TraceParentKey]); err == nil {
+		ctx = tracing.ContextWithRemoteParent(ctx, sc)
+	}
+	ctx, span := server.tracer.Start(ctx, req.h.ServiceMethod, tracing.SpanKindServer)
+	span.SetAttribute(tracing.AttrMethod, req.h.ServiceMethod)
+	if req.conn.peer != "" {
+		span.SetAttribute(tracing.AttrPeer, req.conn.peer)
+	}
+	return ctx, span
+}
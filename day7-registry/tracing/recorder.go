@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//已经结束的 span
+type RecordedSpan struct {
+	Name		string
+	Kind		SpanKind
+	Context		SpanContext
+	Parent		SpanID		//没有父 span 时为零值
+	Attributes	map[string]interface{}
+	Err			error
+	Start		time.Time
+	End			time.Time
+}
+
+//把结束的 span 保存在内存中的 Tracer，用于测试和调试
+type Recorder struct {
+	mu		sync.Mutex
+	spans	[]RecordedSpan
+}
+
+var _ Tracer = (*Recorder)(nil)
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	s := &recorderSpan{recorder: r}
+	s.span = RecordedSpan{
+		Name:		name,
+		Kind:		kind,
+		Attributes:	make(map[string]interface{}),
+		Start:		time.Now(),
+	}
+	s.span.Context.SpanID = NewSpanID()
+	s.span.Context.Sampled = true
+	if parent, ok := ParentFromContext(ctx); ok {
+		s.span.Context.TraceID = parent.TraceID
+		s.span.Parent = parent.SpanID
+	} else {
+		s.span.Context.TraceID = NewTraceID()
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+//已经结束的 span，按结束的顺序
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedSpan(nil), r.spans...)
+}
+
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = nil
+}
+
+type recorderSpan struct {
+	recorder	*Recorder
+	mu			sync.Mutex
+	span		RecordedSpan
+	ended		bool
+}
+
+func (s *recorderSpan) Context() SpanContext {
+	return s.span.Context
+}
+
+func (s *recorderSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.span.Attributes[key] = value
+}
+
+func (s *recorderSpan) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.span.Err = err
+	s.span.Attributes[AttrError] = err.Error()
+}
+
+//只有第一次调用有效
+func (s *recorderSpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.span.End = time.Now()
+	span := s.span
+	attrs := make(map[string]interface{}, len(span.Attributes))
+	for k, v := range span.Attributes {
+		attrs[k] = v
+	}
+	span.Attributes = attrs
+	s.mu.Unlock()
+
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.recorder.spans = append(s.recorder.spans, span)
+}
@@ -0,0 +1,169 @@
+//tracing 定义了 geerpc 使用的追踪接口。Client、XClient 和 Server 在调用前后开始和结束 span，
+//并用 W3C traceparent 格式在请求头的元数据里传递追踪ID。
+//接入 OpenTelemetry 等系统时实现 Tracer 即可，Recorder 是用于测试的内存实现。
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//请求头元数据中保存追踪ID的键
+const TraceParentKey = "traceparent"
+
+//span 的类型
+type SpanKind int
+
+const (
+	SpanKindInternal	SpanKind = iota
+	SpanKindClient
+	SpanKindServer
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindClient:
+		return "client"
+	case SpanKindServer:
+		return "server"
+	}
+	return "internal"
+}
+
+//常用的属性名
+const (
+	AttrMethod	= "rpc.method"		//Service.Method
+	AttrPeer	= "net.peer.addr"	//对端地址
+	AttrSeq		= "rpc.seq"			//请求的序列号
+	AttrError	= "error"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+//跨进程传递的 span 标识
+type SpanContext struct {
+	TraceID	TraceID
+	SpanID	SpanID
+	Sampled	bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+//W3C traceparent 格式：00-<trace-id>-<span-id>-<flags>
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+var errInvalidTraceParent = errors.New("tracing: invalid traceparent")
+
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		return sc, errInvalidTraceParent
+	}
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return sc, err
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return sc, err
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, errInvalidTraceParent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, errInvalidTraceParent
+	}
+	return sc, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) {
+		return errInvalidTraceParent
+	}
+	if _, err := hex.Decode(dst, []byte(s)); err != nil {
+		return errInvalidTraceParent
+	}
+	return nil
+}
+
+//随机生成ID
+func NewTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func NewSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+//一次操作
+type Span interface {
+	Context() SpanContext
+	SetAttribute(key string, value interface{})
+	//记录错误，err 为空时忽略
+	SetError(err error)
+	End()
+}
+
+//创建 span。父 span 从 ctx 中取得：本进程的用 SpanFromContext，
+//从请求中解析出的远端父 span 用 RemoteParentFromContext。
+//返回的 ctx 应该带上新的 span，以便后续的调用以它为父 span。
+type Tracer interface {
+	Start(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+//ctx 中的当前 span，没有时为 nil
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+//设置从请求中解析出的远端父 span
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func RemoteParentFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+//新 span 的父 span：优先本进程的 span，其次远端父 span
+func ParentFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context(), true
+	}
+	return RemoteParentFromContext(ctx)
+}
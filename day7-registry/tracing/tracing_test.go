@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTraceParent(t *testing.T) {
+	sc := SpanContext{TraceID: NewTraceID(), SpanID: NewSpanID(), Sampled: true}
+	parsed, err := ParseTraceParent(sc.TraceParent())
+	if err != nil || parsed != sc {
+		t.Fatalf("round trip failed: %v %+v %+v", err, parsed, sc)
+	}
+	for _, bad := range []string{
+		"",
+		"00-abc-def-01",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-zzad6b7169203331-01",
+	} {
+		if _, err := ParseTraceParent(bad); err == nil {
+			t.Fatalf("expect an error for %q", bad)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	ctx, root := r.Start(context.Background(), "root", SpanKindInternal)
+	_, child := r.Start(ctx, "child", SpanKindClient)
+	child.SetError(errors.New("failed"))
+	child.End()
+	child.End()
+	root.End()
+
+	//远端父 span
+	remote := SpanContext{TraceID: NewTraceID(), SpanID: NewSpanID()}
+	_, server := r.Start(ContextWithRemoteParent(context.Background(), remote), "server", SpanKindServer)
+	server.End()
+
+	spans := r.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("expect 3 spans, got %d", len(spans))
+	}
+	if spans[0].Context.TraceID != spans[1].Context.TraceID || spans[0].Parent != spans[1].Context.SpanID {
+		t.Fatal("expect child to continue the root trace")
+	}
+	if spans[0].Attributes[AttrError] != "failed" {
+		t.Fatalf("expect the error attribute, got %v", spans[0].Attributes)
+	}
+	if spans[2].Context.TraceID != remote.TraceID || spans[2].Parent != remote.SpanID {
+		t.Fatal("expect server span to continue the remote trace")
+	}
+}
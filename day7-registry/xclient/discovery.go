@@ -10,10 +10,11 @@ import (
 
 type SelectMode int
 
-//随机选择，轮询模式
+//随机选择，轮询模式，按权重随机选择
 const (
 	RandomSelect	SelectMode = iota
 	RoundRobinSelect
+	WeightedRandomSelect
 )
 //服务发现的基本接口
 
@@ -29,6 +30,13 @@ type Discovery interface {
 }
 
 
+//按服务区分的服务发现，XClient 调用 Service.Method 时
+//会通过 Service(name) 取得只包含提供该服务的服务器的 Discovery。
+type ServiceDiscovery interface {
+	Discovery
+	Service(name string) Discovery
+}
+
 //手工维护的服务发现结构体
 type MultiServersDiscovery struct {
 	//随机数，用于生成轮询
@@ -39,6 +47,8 @@ type MultiServersDiscovery struct {
 	servers []string
 	//当前被选的轮询序号
 	index	int
+	//服务器的权重，没有设置或<=0 时按 1 计算
+	weights	map[string]int
 }
 //构造函数直接通过已有的servers建立
 func NewMultiServerDiscovery(servers []string) *MultiServersDiscovery{
@@ -62,6 +72,26 @@ func (d *MultiServersDiscovery) Update(servers []string) error {
 	d.servers = servers
 	return nil
 }
+//设置各个服务器的权重，用于 WeightedRandomSelect
+func (d *MultiServersDiscovery) SetWeights(weights map[string]int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.weights = weights
+}
+//服务器的权重，没有设置时 ok 为 false
+func (d *MultiServersDiscovery) Weight(addr string) (weight int, ok bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	weight, ok = d.weights[addr]
+	return
+}
+
+func (d *MultiServersDiscovery) weight(server string) int {
+	if w := d.weights[server]; w > 0 {
+		return w
+	}
+	return 1
+}
 //根据模式获取一个服务器
 func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
 	d.mu.Lock()
@@ -77,6 +107,18 @@ func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
 		s := d.servers[d.index%n]
 		d.index = (d.index + 1) % n 
 		return s, nil
+	case WeightedRandomSelect:
+		total := 0
+		for _, s := range d.servers {
+			total += d.weight(s)
+		}
+		k := d.r.Intn(total)
+		for _, s := range d.servers {
+			if k -= d.weight(s); k < 0 {
+				return s, nil
+			}
+		}
+		return d.servers[n-1], nil
 	default:
 		return "", errors.New("rpc discovery: not supported select mode")
 	}
@@ -0,0 +1,445 @@
+package xclient
+
+import (
+	"errors"
+	"geerpc"
+	"geerpc/registry"
+	"regexp"
+	"sync"
+)
+
+//包装其他 Discovery 的装饰器：合并、过滤、兜底列表和缓存。
+//它们只实现 GetAll，Get 在 GetAll 的结果上按模式选择。
+//被包装的 Discovery 的权重、元数据和按服务区分的 Service 都会转发。
+
+//能返回服务器元数据的 Discovery，比如 GeeRegistryDiscovery、FileDiscovery
+type instanceSource interface {
+	Instance(addr string) (registry.ServerItem, bool)
+}
+
+//能返回服务器权重的 Discovery，比如 DNSDiscovery 的 SRV 权重
+type weightSource interface {
+	Weight(addr string) (int, bool)
+}
+
+//服务器的权重，先看 src 设置的权重，再看元数据中的权重
+func weightOf(src Discovery, addr string) (int, bool) {
+	if ws, ok := src.(weightSource); ok {
+		if w, ok := ws.Weight(addr); ok {
+			return w, true
+		}
+	}
+	if is, ok := src.(instanceSource); ok {
+		if item, ok := is.Instance(addr); ok {
+			return item.Weight, true
+		}
+	}
+	return 0, false
+}
+
+//按服务名缓存包装后的 Discovery，每个服务保留自己的轮询位置
+type serviceCache struct {
+	mu			sync.Mutex
+	services	map[string]Discovery
+}
+
+func (c *serviceCache) get(name string, wrap func() Discovery) Discovery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.services[name]
+	if !ok {
+		if c.services == nil {
+			c.services = make(map[string]Discovery)
+		}
+		d = wrap()
+		c.services[name] = d
+	}
+	return d
+}
+
+var errUpdateNotSupported = errors.New("rpc discovery: update not supported")
+
+//在动态的服务器列表上按模式选择，保留轮询的位置
+type selector struct {
+	mu	sync.Mutex
+	sel	*MultiServersDiscovery
+}
+
+func newSelector() selector {
+	return selector{sel: NewMultiServerDiscovery(make([]string, 0))}
+}
+
+func (s *selector) pick(src Discovery, servers []string, mode SelectMode) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.sel.Update(servers)
+	if mode == WeightedRandomSelect {
+		weights := make(map[string]int, len(servers))
+		for _, addr := range servers {
+			if w, ok := weightOf(src, addr); ok {
+				weights[addr] = w
+			}
+		}
+		s.sel.SetWeights(weights)
+	}
+	return s.sel.Get(mode)
+}
+
+//<-----------------------合并------------------------->
+
+//合并多个来源的服务器列表，去掉重复的地址。
+//部分来源出错时使用其余来源的结果，全部出错才返回错误。
+type MergeDiscovery struct {
+	ds			[]Discovery
+	services	serviceCache
+	selector
+}
+
+var _ ServiceDiscovery = (*MergeDiscovery)(nil)
+
+func NewMergeDiscovery(ds ...Discovery) *MergeDiscovery {
+	return &MergeDiscovery{ds: ds, selector: newSelector()}
+}
+
+func (d *MergeDiscovery) Refresh() error {
+	var err error
+	ok := false
+	for _, src := range d.ds {
+		if e := src.Refresh(); e != nil {
+			err = e
+		} else {
+			ok = true
+		}
+	}
+	if ok || len(d.ds) == 0 {
+		return nil
+	}
+	return err
+}
+
+//合并后的列表来自多个来源，不能整体更新
+func (d *MergeDiscovery) Update(servers []string) error {
+	return errUpdateNotSupported
+}
+
+func (d *MergeDiscovery) Get(mode SelectMode) (string, error) {
+	servers, err := d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	return d.pick(d, servers, mode)
+}
+
+func (d *MergeDiscovery) GetAll() ([]string, error) {
+	var err error
+	ok := false
+	seen := make(map[string]bool)
+	servers := make([]string, 0)
+	for _, src := range d.ds {
+		list, e := src.GetAll()
+		if e != nil {
+			geerpc.DefaultLogger.Log(geerpc.LevelWarn, "rpc discovery: merge source failed", geerpc.F(geerpc.FieldError, e))
+			err = e
+			continue
+		}
+		ok = true
+		for _, s := range list {
+			if !seen[s] {
+				seen[s] = true
+				servers = append(servers, s)
+			}
+		}
+	}
+	if !ok && err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+//第一个有该地址元数据的来源为准
+func (d *MergeDiscovery) Instance(addr string) (registry.ServerItem, bool) {
+	for _, src := range d.ds {
+		if is, ok := src.(instanceSource); ok {
+			if item, ok := is.Instance(addr); ok {
+				return item, true
+			}
+		}
+	}
+	return registry.ServerItem{}, false
+}
+
+//第一个有该地址权重的来源为准
+func (d *MergeDiscovery) Weight(addr string) (int, bool) {
+	for _, src := range d.ds {
+		if w, ok := weightOf(src, addr); ok {
+			return w, true
+		}
+	}
+	return 0, false
+}
+
+//合并每个来源中提供 name 服务的服务器，不区分服务的来源整体参与合并
+func (d *MergeDiscovery) Service(name string) Discovery {
+	scoped := false
+	for _, src := range d.ds {
+		if _, ok := src.(ServiceDiscovery); ok {
+			scoped = true
+		}
+	}
+	if !scoped {
+		return d
+	}
+	return d.services.get(name, func() Discovery {
+		ds := make([]Discovery, len(d.ds))
+		for i, src := range d.ds {
+			ds[i] = serviceOf(src, name)
+		}
+		return NewMergeDiscovery(ds...)
+	})
+}
+
+//d 按服务区分时返回 name 服务的 Discovery，否则返回 d
+func serviceOf(d Discovery, name string) Discovery {
+	if sd, ok := d.(ServiceDiscovery); ok {
+		return sd.Service(name)
+	}
+	return d
+}
+
+//<-----------------------过滤------------------------->
+
+//只保留 keep 返回 true 的服务器
+type FilterDiscovery struct {
+	d			Discovery
+	keep		func(addr string) bool
+	services	serviceCache
+	selector
+}
+
+var _ ServiceDiscovery = (*FilterDiscovery)(nil)
+
+func NewFilterDiscovery(d Discovery, keep func(addr string) bool) *FilterDiscovery {
+	return &FilterDiscovery{d: d, keep: keep, selector: newSelector()}
+}
+
+//地址匹配正则表达式，比如 `^tcp@10\.0\.`
+func AddrMatch(re *regexp.Regexp) func(addr string) bool {
+	return re.MatchString
+}
+
+//按 d 提供的元数据过滤，没有元数据的服务器不保留
+func MetadataMatch(d Discovery, filter InstanceFilter) func(addr string) bool {
+	return func(addr string) bool {
+		is, ok := d.(instanceSource)
+		if !ok {
+			return false
+		}
+		item, ok := is.Instance(addr)
+		return ok && filter(item)
+	}
+}
+
+func (d *FilterDiscovery) Refresh() error {
+	return d.d.Refresh()
+}
+
+func (d *FilterDiscovery) Update(servers []string) error {
+	return d.d.Update(servers)
+}
+
+func (d *FilterDiscovery) Get(mode SelectMode) (string, error) {
+	servers, err := d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	return d.pick(d, servers, mode)
+}
+
+func (d *FilterDiscovery) GetAll() ([]string, error) {
+	list, err := d.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]string, 0, len(list))
+	for _, s := range list {
+		if d.keep(s) {
+			servers = append(servers, s)
+		}
+	}
+	return servers, nil
+}
+
+func (d *FilterDiscovery) Instance(addr string) (registry.ServerItem, bool) {
+	if is, ok := d.d.(instanceSource); ok {
+		return is.Instance(addr)
+	}
+	return registry.ServerItem{}, false
+}
+
+func (d *FilterDiscovery) Weight(addr string) (int, bool) {
+	return weightOf(d.d, addr)
+}
+
+func (d *FilterDiscovery) Service(name string) Discovery {
+	if _, ok := d.d.(ServiceDiscovery); !ok {
+		return d
+	}
+	return d.services.get(name, func() Discovery {
+		return NewFilterDiscovery(serviceOf(d.d, name), d.keep)
+	})
+}
+
+//<-----------------------兜底列表------------------------->
+
+//主 Discovery 出错或者返回空列表时，使用固定的兜底列表
+type FallbackDiscovery struct {
+	primary		Discovery
+	fallback	[]string
+	services	serviceCache
+	selector
+}
+
+var _ ServiceDiscovery = (*FallbackDiscovery)(nil)
+
+func NewFallbackDiscovery(primary Discovery, fallback []string) *FallbackDiscovery {
+	return &FallbackDiscovery{primary: primary, fallback: fallback, selector: newSelector()}
+}
+
+//有兜底列表，主 Discovery 出错也不算错误
+func (d *FallbackDiscovery) Refresh() error {
+	if err := d.primary.Refresh(); err != nil {
+		geerpc.DefaultLogger.Log(geerpc.LevelWarn, "rpc discovery: primary refresh failed, using fallback", geerpc.F(geerpc.FieldError, err))
+	}
+	return nil
+}
+
+func (d *FallbackDiscovery) Update(servers []string) error {
+	return d.primary.Update(servers)
+}
+
+func (d *FallbackDiscovery) Get(mode SelectMode) (string, error) {
+	servers, err := d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	return d.pick(d, servers, mode)
+}
+
+func (d *FallbackDiscovery) GetAll() ([]string, error) {
+	servers, err := d.primary.GetAll()
+	if err == nil && len(servers) > 0 {
+		return servers, nil
+	}
+	if err != nil {
+		geerpc.DefaultLogger.Log(geerpc.LevelWarn, "rpc discovery: primary failed, using fallback", geerpc.F(geerpc.FieldError, err))
+	}
+	ret := make([]string, len(d.fallback))
+	copy(ret, d.fallback)
+	return ret, nil
+}
+
+func (d *FallbackDiscovery) Instance(addr string) (registry.ServerItem, bool) {
+	if is, ok := d.primary.(instanceSource); ok {
+		return is.Instance(addr)
+	}
+	return registry.ServerItem{}, false
+}
+
+func (d *FallbackDiscovery) Weight(addr string) (int, bool) {
+	return weightOf(d.primary, addr)
+}
+
+//兜底列表不区分服务，所有服务共用
+func (d *FallbackDiscovery) Service(name string) Discovery {
+	if _, ok := d.primary.(ServiceDiscovery); !ok {
+		return d
+	}
+	return d.services.get(name, func() Discovery {
+		return NewFallbackDiscovery(serviceOf(d.primary, name), d.fallback)
+	})
+}
+
+//<-----------------------缓存------------------------->
+
+//记住最近一次成功拿到的服务器列表，d 出错时（比如注册中心不可用）返回缓存的列表
+type CachedDiscovery struct {
+	d		Discovery
+	cacheMu	sync.Mutex
+	cached	[]string
+	ok		bool			//是否成功拿到过列表
+	services	serviceCache
+	selector
+}
+
+var _ ServiceDiscovery = (*CachedDiscovery)(nil)
+
+func NewCachedDiscovery(d Discovery) *CachedDiscovery {
+	return &CachedDiscovery{d: d, selector: newSelector()}
+}
+
+//有缓存时出错只记录日志
+func (d *CachedDiscovery) Refresh() error {
+	err := d.d.Refresh()
+	if err != nil && d.hasCache() {
+		geerpc.DefaultLogger.Log(geerpc.LevelWarn, "rpc discovery: refresh failed, using cached servers", geerpc.F(geerpc.FieldError, err))
+		return nil
+	}
+	return err
+}
+
+func (d *CachedDiscovery) hasCache() bool {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	return d.ok
+}
+
+func (d *CachedDiscovery) Update(servers []string) error {
+	return d.d.Update(servers)
+}
+
+func (d *CachedDiscovery) Get(mode SelectMode) (string, error) {
+	servers, err := d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	return d.pick(d, servers, mode)
+}
+
+func (d *CachedDiscovery) GetAll() ([]string, error) {
+	servers, err := d.d.GetAll()
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	if err != nil {
+		if !d.ok {
+			return nil, err
+		}
+		geerpc.DefaultLogger.Log(geerpc.LevelWarn, "rpc discovery: using cached servers", geerpc.F(geerpc.FieldError, err))
+		servers = d.cached
+	} else {
+		d.cached, d.ok = servers, true
+	}
+	ret := make([]string, len(servers))
+	copy(ret, servers)
+	return ret, nil
+}
+
+func (d *CachedDiscovery) Instance(addr string) (registry.ServerItem, bool) {
+	if is, ok := d.d.(instanceSource); ok {
+		return is.Instance(addr)
+	}
+	return registry.ServerItem{}, false
+}
+
+func (d *CachedDiscovery) Weight(addr string) (int, bool) {
+	return weightOf(d.d, addr)
+}
+
+//每个服务各自缓存
+func (d *CachedDiscovery) Service(name string) Discovery {
+	if _, ok := d.d.(ServiceDiscovery); !ok {
+		return d
+	}
+	return d.services.get(name, func() Discovery {
+		return NewCachedDiscovery(serviceOf(d.d, name))
+	})
+}
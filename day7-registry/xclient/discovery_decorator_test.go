@@ -0,0 +1,146 @@
+package xclient
+
+import (
+	"errors"
+	"geerpc"
+	"geerpc/registry"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// 可以切换成出错状态的 Discovery
+type flakyDiscovery struct {
+	*MultiServersDiscovery
+	err error
+}
+
+func (d *flakyDiscovery) GetAll() ([]string, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+func TestDiscoveryDecorators(t *testing.T) {
+	down := errors.New("registry down")
+
+	t.Run("merge", func(t *testing.T) {
+		a := NewMultiServerDiscovery([]string{"tcp@a", "tcp@b"})
+		b := NewMultiServerDiscovery([]string{"tcp@b", "tcp@c"})
+		bad := &flakyDiscovery{NewMultiServerDiscovery(nil), down}
+		d := NewMergeDiscovery(a, bad, b)
+		servers, err := d.GetAll()
+		if err != nil || len(servers) != 3 {
+			t.Fatalf("expect 3 merged servers, got %v %v", servers, err)
+		}
+		if _, err := NewMergeDiscovery(bad).Get(RoundRobinSelect); err == nil {
+			t.Fatal("expect an error when every source fails")
+		}
+	})
+	t.Run("filter", func(t *testing.T) {
+		src := NewMultiServerDiscovery([]string{"tcp@10.0.0.1:1", "tcp@192.168.0.1:1"})
+		d := NewFilterDiscovery(src, AddrMatch(regexp.MustCompile(`^tcp@10\.`)))
+		if s, err := d.Get(RandomSelect); err != nil || s != "tcp@10.0.0.1:1" {
+			t.Fatalf("unexpected filtered server: %s %v", s, err)
+		}
+	})
+	t.Run("metadata", func(t *testing.T) {
+		ts := httptest.NewServer(registry.New(0))
+		defer ts.Close()
+		registry.HeartbeatServer(ts.URL, geerpc.NewServer(), registry.ServerItem{Addr: "tcp@a", Zone: "z1"}, time.Hour)
+		registry.HeartbeatServer(ts.URL, geerpc.NewServer(), registry.ServerItem{Addr: "tcp@b", Zone: "z2"}, time.Hour)
+		src := NewGeeRegistryDiscovery(ts.URL, 0)
+		d := NewFilterDiscovery(src, MetadataMatch(src, ZoneFilter("z2")))
+		if servers, err := d.GetAll(); err != nil || len(servers) != 1 || servers[0] != "tcp@b" {
+			t.Fatalf("unexpected filtered servers: %v %v", servers, err)
+		}
+	})
+	t.Run("fallback", func(t *testing.T) {
+		primary := &flakyDiscovery{NewMultiServerDiscovery(nil), nil}
+		d := NewFallbackDiscovery(primary, []string{"tcp@static"})
+		if s, err := d.Get(RoundRobinSelect); err != nil || s != "tcp@static" {
+			t.Fatalf("expect fallback on empty list, got %s %v", s, err)
+		}
+		primary.err = down
+		if s, err := d.Get(RoundRobinSelect); err != nil || s != "tcp@static" {
+			t.Fatalf("expect fallback on error, got %s %v", s, err)
+		}
+		primary.err = nil
+		_ = primary.Update([]string{"tcp@live"})
+		if s, _ := d.Get(RoundRobinSelect); s != "tcp@live" {
+			t.Fatalf("expect primary server, got %s", s)
+		}
+	})
+	t.Run("cache", func(t *testing.T) {
+		src := &flakyDiscovery{NewMultiServerDiscovery(nil), down}
+		d := NewCachedDiscovery(src)
+		if _, err := d.GetAll(); err == nil {
+			t.Fatal("expect an error before any list is cached")
+		}
+		src.err = nil
+		_ = src.Update([]string{"tcp@a"})
+		if _, err := d.GetAll(); err != nil {
+			t.Fatal(err)
+		}
+		src.err = down
+		if s, err := d.Get(RoundRobinSelect); err != nil || s != "tcp@a" {
+			t.Fatalf("expect cached server, got %s %v", s, err)
+		}
+	})
+}
+
+// 按服务区分的 Discovery，每个服务有自己的服务器列表
+type scopedDiscovery struct {
+	*MultiServersDiscovery
+	services map[string]Discovery
+}
+
+func (d *scopedDiscovery) Service(name string) Discovery {
+	return d.services[name]
+}
+
+func TestDiscoveryDecorators_Forward(t *testing.T) {
+	wrap := map[string]func(d Discovery) Discovery{
+		"merge":    func(d Discovery) Discovery { return NewMergeDiscovery(d) },
+		"filter":   func(d Discovery) Discovery { return NewFilterDiscovery(d, func(string) bool { return true }) },
+		"fallback": func(d Discovery) Discovery { return NewFallbackDiscovery(d, []string{"tcp@static"}) },
+		"cache":    func(d Discovery) Discovery { return NewCachedDiscovery(d) },
+	}
+	for name, wrap := range wrap {
+		t.Run(name, func(t *testing.T) {
+			// 每个服务只用提供它的服务器
+			scoped := &scopedDiscovery{
+				MultiServersDiscovery: NewMultiServerDiscovery([]string{"tcp@a", "tcp@b"}),
+				services: map[string]Discovery{
+					"Foo": NewMultiServerDiscovery([]string{"tcp@a"}),
+					"Bar": NewMultiServerDiscovery([]string{"tcp@b"}),
+				},
+			}
+			sd, ok := wrap(scoped).(ServiceDiscovery)
+			if !ok {
+				t.Fatal("expect the decorator to be a ServiceDiscovery")
+			}
+			for i := 0; i < 10; i++ {
+				if s, err := sd.Service("Foo").Get(RoundRobinSelect); err != nil || s != "tcp@a" {
+					t.Fatalf("expect only the server of Foo, got %s %v", s, err)
+				}
+			}
+
+			// 保留来源的权重
+			weighted := NewMultiServerDiscovery([]string{"tcp@heavy", "tcp@light"})
+			weighted.SetWeights(map[string]int{"tcp@heavy": 1000, "tcp@light": 1})
+			d := wrap(weighted)
+			heavy := 0
+			for i := 0; i < 200; i++ {
+				if s, _ := d.Get(WeightedRandomSelect); s == "tcp@heavy" {
+					heavy++
+				}
+			}
+			if heavy < 190 {
+				t.Fatalf("expect weights to be used, heavy picked %d/200", heavy)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DNS 查询接口，*net.Resolver 实现了这个接口。
+//测试时可以用 Dial 指向本地DNS服务器的 *net.Resolver。
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+//定期通过DNS解析服务器列表，可以解析 A/AAAA 记录（name 为 host:port）
+//或者 SRV 记录（name 为 _service._proto.domain）。
+//解析结果转换为 XDial 用的 protocol@addr 格式，解析失败时保留上一次的列表。
+type DNSDiscovery struct {
+	*MultiServersDiscovery
+	protocol	string		//XDial 的协议，比如 tcp、http
+	name		string
+	srv			bool
+	resolver	Resolver
+	interval	time.Duration
+
+	logMu		sync.Mutex
+	logger		geerpc.Logger	//为空时使用 geerpc.DefaultLogger
+
+	stop		chan struct{}
+	done		chan struct{}
+	once		sync.Once
+}
+
+const (
+	defaultDNSInterval	= time.Second * 30
+	dnsLookupTimeout	= time.Second * 5
+)
+
+//解析 A/AAAA 记录，hostport 形如 rpc.example.com:9999
+func NewDNSDiscovery(protocol, hostport string, resolver Resolver, interval time.Duration) (*DNSDiscovery, error) {
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		return nil, err
+	}
+	return newDNSDiscovery(protocol, hostport, false, resolver, interval)
+}
+
+//解析 SRV 记录，name 形如 _geerpc._tcp.example.com
+func NewDNSSRVDiscovery(protocol, name string, resolver Resolver, interval time.Duration) (*DNSDiscovery, error) {
+	return newDNSDiscovery(protocol, name, true, resolver, interval)
+}
+
+//先同步解析一次，失败返回错误，之后在后台每隔 interval 解析一次
+func newDNSDiscovery(protocol, name string, srv bool, resolver Resolver, interval time.Duration) (*DNSDiscovery, error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if interval == 0 {
+		interval = defaultDNSInterval
+	}
+	d := &DNSDiscovery{
+		MultiServersDiscovery:	NewMultiServerDiscovery(make([]string, 0)),
+		protocol:				protocol,
+		name:					name,
+		srv:					srv,
+		resolver:				resolver,
+		interval:				interval,
+		stop:					make(chan struct{}),
+		done:					make(chan struct{}),
+	}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	go d.watch()
+	return d, nil
+}
+
+//设置解析失败的日志，为空时使用 geerpc.DefaultLogger
+func (d *DNSDiscovery) SetLogger(l geerpc.Logger) {
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+	d.logger = l
+}
+
+func (d *DNSDiscovery) log() geerpc.Logger {
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+	if d.logger == nil {
+		return geerpc.DefaultLogger
+	}
+	return d.logger
+}
+
+//停止定期解析
+func (d *DNSDiscovery) Close() error {
+	d.once.Do(func() {
+		close(d.stop)
+	})
+	<-d.done
+	return nil
+}
+
+func (d *DNSDiscovery) watch() {
+	defer close(d.done)
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-t.C:
+		}
+		if err := d.Refresh(); err != nil {
+			d.log().Log(geerpc.LevelWarn, "rpc discovery: resolve failed", geerpc.F("name", d.name), geerpc.F(geerpc.FieldError, err))
+		}
+	}
+}
+
+//立即解析一次
+func (d *DNSDiscovery) Refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	var servers []string
+	var weights map[string]int
+	var err error
+	if d.srv {
+		servers, weights, err = d.lookupSRV(ctx)
+	} else {
+		servers, err = d.lookupHost(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		return errors.New("rpc discovery: no records for " + d.name)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.servers, d.weights = servers, weights
+	return nil
+}
+
+func (d *DNSDiscovery) lookupHost(ctx context.Context) ([]string, error) {
+	host, port, _ := net.SplitHostPort(d.name)
+	addrs, err := d.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		servers = append(servers, d.protocol+"@"+net.JoinHostPort(addr, port))
+	}
+	return servers, nil
+}
+
+//只使用优先级最高（Priority 最小）的一组记录，Weight 作为权重
+func (d *DNSDiscovery) lookupSRV(ctx context.Context) ([]string, map[string]int, error) {
+	_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, nil
+	}
+	best := records[0].Priority
+	for _, r := range records {
+		if r.Priority < best {
+			best = r.Priority
+		}
+	}
+	var servers []string
+	weights := make(map[string]int)
+	for _, r := range records {
+		if r.Priority != best {
+			continue
+		}
+		addr := d.protocol + "@" + net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+		servers = append(servers, addr)
+		weights[addr] = int(r.Weight)
+	}
+	return servers, weights, nil
+}
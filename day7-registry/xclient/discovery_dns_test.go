@@ -0,0 +1,197 @@
+package xclient
+
+import (
+	"context"
+	"encoding/binary"
+	"geerpc"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 本地的DNS服务器，只回答 A 和 SRV 查询
+type stubDNS struct {
+	conn net.PacketConn
+	mu   sync.Mutex
+	a    map[string][]net.IP
+	srv  map[string][]net.SRV
+}
+
+func startStubDNS(t *testing.T) *stubDNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubDNS{conn: conn, a: map[string][]net.IP{}, srv: map[string][]net.SRV{}}
+	go s.serve()
+	t.Cleanup(func() { _ = conn.Close() })
+	return s
+}
+
+func (s *stubDNS) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return net.Dial("udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *stubDNS) set(a map[string][]net.IP, srv map[string][]net.SRV) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.a, s.srv = a, srv
+}
+
+func (s *stubDNS) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.answer(buf[:n]); resp != nil {
+			_, _ = s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func (s *stubDNS) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	//解析问题部分的域名
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		l := int(query[i])
+		labels = append(labels, string(query[i+1:i+1+l]))
+		i += 1 + l
+	}
+	i++
+	qtype := binary.BigEndian.Uint16(query[i:])
+	question := query[12 : i+4]
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var answers [][]byte
+	rr := func(typ uint16, rdata []byte) []byte {
+		b := []byte{0xc0, 12} //指向问题中的域名
+		b = appendUint16(b, typ)
+		b = appendUint16(b, 1)
+		b = appendUint32(b, 60)
+		b = appendUint16(b, uint16(len(rdata)))
+		return append(b, rdata...)
+	}
+	switch qtype {
+	case 1: // A
+		for _, ip := range s.a[name] {
+			answers = append(answers, rr(1, ip.To4()))
+		}
+	case 33: // SRV
+		for _, r := range s.srv[name] {
+			rdata := appendUint16(nil, r.Priority)
+			rdata = appendUint16(rdata, r.Weight)
+			rdata = appendUint16(rdata, r.Port)
+			answers = append(answers, rr(33, append(rdata, encodeName(r.Target)...)))
+		}
+	}
+	resp := append([]byte{}, query[:2]...)
+	resp = append(resp, 0x81, 0x80, 0, 1)
+	resp = appendUint16(resp, uint16(len(answers)))
+	resp = append(resp, 0, 0, 0, 0)
+	resp = append(resp, question...)
+	for _, a := range answers {
+		resp = append(resp, a...)
+	}
+	return resp
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	dns := startStubDNS(t)
+	dns.set(map[string][]net.IP{"rpc.example.com": {net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}}, nil)
+
+	d, err := NewDNSDiscovery("tcp", "rpc.example.com:9999", dns.resolver(), time.Millisecond*20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+	var warns int32
+	d.SetLogger(logFunc(func(level geerpc.Level, msg string, fields ...geerpc.Field) {
+		if level == geerpc.LevelWarn {
+			atomic.AddInt32(&warns, 1)
+		}
+	}))
+	if servers, _ := d.GetAll(); strings.Join(servers, ",") != "tcp@10.0.0.1:9999,tcp@10.0.0.2:9999" {
+		t.Fatalf("unexpected servers: %v", servers)
+	}
+
+	//记录变化后定期解析会更新列表
+	dns.set(map[string][]net.IP{"rpc.example.com": {net.ParseIP("10.0.0.3")}}, nil)
+	waitFor(t, func() bool {
+		servers, _ := d.GetAll()
+		return len(servers) == 1 && servers[0] == "tcp@10.0.0.3:9999"
+	})
+	//解析不到记录时保留上一次的列表
+	dns.set(nil, nil)
+	time.Sleep(time.Millisecond * 60)
+	if servers, _ := d.GetAll(); len(servers) != 1 {
+		t.Fatalf("expect the last good list, got %v", servers)
+	}
+	if atomic.LoadInt32(&warns) == 0 {
+		t.Fatal("expect resolve failures to go to the logger set with SetLogger")
+	}
+}
+
+func TestDNSSRVDiscovery(t *testing.T) {
+	dns := startStubDNS(t)
+	dns.set(nil, map[string][]net.SRV{"_geerpc._tcp.example.com": {
+		{Target: "a.example.com.", Port: 7001, Priority: 10, Weight: 90},
+		{Target: "b.example.com.", Port: 7002, Priority: 10, Weight: 10},
+		{Target: "backup.example.com.", Port: 7003, Priority: 20, Weight: 100},
+	}})
+
+	d, err := NewDNSSRVDiscovery("http", "_geerpc._tcp.example.com", dns.resolver(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+	servers, _ := d.GetAll()
+	if len(servers) != 2 || strings.Contains(strings.Join(servers, ","), "backup") {
+		t.Fatalf("expect only the highest priority records, got %v", servers)
+	}
+	hits := 0
+	for i := 0; i < 100; i++ {
+		if s, _ := d.Get(WeightedRandomSelect); s == "http@a.example.com:7001" {
+			hits++
+		}
+	}
+	if hits < 70 {
+		t.Fatalf("expect weighted select to favour a.example.com, got %d/100", hits)
+	}
+
+	if _, err := NewDNSSRVDiscovery("tcp", "_none._tcp.example.com", dns.resolver(), 0); err == nil {
+		t.Fatal("expect an error without records")
+	}
+}
@@ -0,0 +1,170 @@
+package xclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"geerpc/registry"
+	"io/ioutil"
+	"geerpc"
+	"os"
+	"sync"
+	"time"
+)
+
+//从本地JSON文件读取服务列表，文件变化后自动重新加载。
+//文件内容可以是地址数组 ["tcp@a", "tcp@b"]，也可以是带元数据的数组
+//[{"addr": "tcp@a", "weight": 2, "zone": "a"}]，或者注册中心的JSON回复 {"servers": [...]}。
+//重新加载只替换服务列表，已经建立的连接和进行中的调用不受影响。
+type FileDiscovery struct {
+	*MultiServersDiscovery
+	path		string
+	interval	time.Duration
+
+	fileMu		sync.Mutex
+	modTime		time.Time
+	size		int64
+	instances	map[string]registry.ServerItem
+
+	logger		geerpc.Logger	//为空时使用 geerpc.DefaultLogger
+
+	stop		chan struct{}
+	done		chan struct{}
+	once		sync.Once
+}
+
+//检查文件是否变化的默认间隔
+const defaultFileCheckInterval = time.Second * 2
+
+//先加载一次文件，加载失败返回错误；之后每隔 interval 检查文件是否变化
+func NewFileDiscovery(path string, interval time.Duration) (*FileDiscovery, error) {
+	if interval == 0 {
+		interval = defaultFileCheckInterval
+	}
+	d := &FileDiscovery{
+		MultiServersDiscovery:	NewMultiServerDiscovery(make([]string, 0)),
+		path:					path,
+		interval:				interval,
+		stop:					make(chan struct{}),
+		done:					make(chan struct{}),
+	}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	go d.watch()
+	return d, nil
+}
+
+//设置重新加载的日志，为空时使用 geerpc.DefaultLogger
+func (d *FileDiscovery) SetLogger(l geerpc.Logger) {
+	d.fileMu.Lock()
+	defer d.fileMu.Unlock()
+	d.logger = l
+}
+
+func (d *FileDiscovery) log() geerpc.Logger {
+	d.fileMu.Lock()
+	defer d.fileMu.Unlock()
+	return d.logLocked()
+}
+
+func (d *FileDiscovery) logLocked() geerpc.Logger {
+	if d.logger == nil {
+		return geerpc.DefaultLogger
+	}
+	return d.logger
+}
+
+//停止检查文件
+func (d *FileDiscovery) Close() error {
+	d.once.Do(func() {
+		close(d.stop)
+	})
+	<-d.done
+	return nil
+}
+
+func (d *FileDiscovery) watch() {
+	defer close(d.done)
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-t.C:
+		}
+		if err := d.Refresh(); err != nil {
+			d.log().Log(geerpc.LevelWarn, "rpc discovery: reload failed", geerpc.F("path", d.path), geerpc.F(geerpc.FieldError, err))
+		}
+	}
+}
+
+//文件有变化时重新加载，内容不合法时保留原来的列表并返回错误，
+//文件再次变化之前不会重新读取
+func (d *FileDiscovery) Refresh() error {
+	d.fileMu.Lock()
+	defer d.fileMu.Unlock()
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(d.modTime) && info.Size() == d.size {
+		return nil
+	}
+	data, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	d.modTime, d.size = info.ModTime(), info.Size()
+	items, err := parseServerFile(data)
+	if err != nil {
+		return fmt.Errorf("rpc discovery: invalid server file %s: %v", d.path, err)
+	}
+	servers := make([]string, 0, len(items))
+	weights := make(map[string]int, len(items))
+	instances := make(map[string]registry.ServerItem, len(items))
+	for _, item := range items {
+		servers = append(servers, item.Addr)
+		weights[item.Addr] = item.Weight
+		instances[item.Addr] = item
+	}
+	d.SetWeights(weights)
+	_ = d.Update(servers)
+	d.instances = instances
+	d.logLocked().Log(geerpc.LevelDebug, "rpc discovery: loaded", geerpc.F("servers", len(servers)), geerpc.F("path", d.path))
+	return nil
+}
+
+//返回服务器在文件中的元数据
+func (d *FileDiscovery) Instance(addr string) (registry.ServerItem, bool) {
+	d.fileMu.Lock()
+	defer d.fileMu.Unlock()
+	item, ok := d.instances[addr]
+	return item, ok
+}
+
+//依次尝试三种格式
+func parseServerFile(data []byte) ([]registry.ServerItem, error) {
+	var addrs []string
+	if err := json.Unmarshal(data, &addrs); err == nil {
+		items := make([]registry.ServerItem, 0, len(addrs))
+		for _, addr := range addrs {
+			items = append(items, registry.ServerItem{Addr: addr})
+		}
+		return items, nil
+	}
+	var items []registry.ServerItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		var list registry.ServerList
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		items = list.Servers
+	}
+	for _, item := range items {
+		if item.Addr == "" {
+			return nil, fmt.Errorf("server without addr")
+		}
+	}
+	return items, nil
+}
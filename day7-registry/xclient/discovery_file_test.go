@@ -0,0 +1,83 @@
+package xclient
+
+import (
+	"geerpc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	//先写临时文件再改名，避免读到写了一半的文件
+	write := func(content string) {
+		if err := ioutil.WriteFile(path+".tmp", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`["tcp@a", "tcp@b"]`)
+	d, err := NewFileDiscovery(path, time.Millisecond*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+	var warns int32
+	d.SetLogger(logFunc(func(level geerpc.Level, msg string, fields ...geerpc.Field) {
+		if level == geerpc.LevelWarn {
+			atomic.AddInt32(&warns, 1)
+		}
+	}))
+	if servers, _ := d.GetAll(); len(servers) != 2 {
+		t.Fatalf("expect 2 servers, got %v", servers)
+	}
+
+	write(`[{"addr": "tcp@c", "weight": 5, "zone": "a"}]`)
+	waitFor(t, func() bool {
+		servers, _ := d.GetAll()
+		return len(servers) == 1 && servers[0] == "tcp@c"
+	})
+	if item, ok := d.Instance("tcp@c"); !ok || item.Weight != 5 || item.Zone != "a" {
+		t.Fatalf("expect metadata of tcp@c, got %+v", item)
+	}
+
+	//内容不合法时保留原来的列表
+	write(`[{"weight": 1}, "broken"`)
+	time.Sleep(time.Millisecond * 50)
+	if servers, _ := d.GetAll(); len(servers) != 1 || servers[0] != "tcp@c" {
+		t.Fatalf("expect the last good list, got %v", servers)
+	}
+	//同一份不合法的内容只读取、报告一次
+	if n := atomic.LoadInt32(&warns); n != 1 {
+		t.Fatalf("expect 1 warning for the invalid file, got %d", n)
+	}
+
+	write(`{"servers": [{"addr": "tcp@d"}, {"addr": "tcp@e"}]}`)
+	waitFor(t, func() bool {
+		servers, _ := d.GetAll()
+		return len(servers) == 2
+	})
+}
+
+func TestFileDiscovery_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	if _, err := NewFileDiscovery(path, 0); err == nil {
+		t.Fatal("expect an error for a missing file")
+	}
+	_ = ioutil.WriteFile(path, []byte(`[{"zone": "a"}]`), 0644)
+	if _, err := NewFileDiscovery(path, 0); err == nil {
+		t.Fatal("expect an error for a server without addr")
+	}
+}
+
+// 用函数实现 geerpc.Logger
+type logFunc func(level geerpc.Level, msg string, fields ...geerpc.Field)
+
+func (f logFunc) Log(level geerpc.Level, msg string, fields ...geerpc.Field) {
+	f(level, msg, fields...)
+}
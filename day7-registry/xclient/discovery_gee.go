@@ -1,64 +1,300 @@
 package xclient
 
 import (
+	"encoding/json"
+	"fmt"
+	"geerpc/registry"
 	"time"
-	"log"
+	"geerpc"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type GeeRegistryDiscovery struct {
 	*MultiServersDiscovery
-	registry 	string
+	registry 	string		//注册中心地址，多个地址用逗号分隔
+	registries	[]string	//拆分后的地址，出错时依次切换
+	current		int			//当前使用的地址
 	timeout		time.Duration
 	lastUpdate	time.Time
+	raw			[]registry.ServerItem			//注册中心返回的原始列表
+	instances	map[string]registry.ServerItem	//过滤后的服务器的元数据
+	filter		InstanceFilter
+	prefer		InstanceFilter
+
+	//后台刷新，同一时间只有一个刷新请求
+	httpClient	*http.Client
+	refreshing	chan struct{}	//刷新中时不为空，刷新结束后关闭
+	lastAttempt	time.Time		//最近一次刷新的时间，不论成败
+	lastErr		error			//最近一次刷新的错误，成功时为空
 }
 
-const defaultUpdateTimeout = time.Second * 10
+//按元数据筛选服务器
+type InstanceFilter func(item registry.ServerItem) bool
+
+//只要指定版本的服务器
+func VersionFilter(version string) InstanceFilter {
+	return func(item registry.ServerItem) bool {
+		return item.Version == version
+	}
+}
+
+//只要指定可用区的服务器
+func ZoneFilter(zone string) InstanceFilter {
+	return func(item registry.ServerItem) bool {
+		return item.Zone == zone
+	}
+}
 
+//不要从快照恢复后还没有重新发送心跳的服务器
+func ConfirmedFilter() InstanceFilter {
+	return func(item registry.ServerItem) bool {
+		return !item.Unconfirmed
+	}
+}
+
+//只要带有 tag 的服务器
+func TagFilter(tag string) InstanceFilter {
+	return func(item registry.ServerItem) bool {
+		for _, t := range item.Tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+const (
+	defaultUpdateTimeout	= time.Second * 10
+	//每次请求注册中心的超时时间
+	defaultRefreshTimeout	= time.Second * 5
+	//刷新失败后，至少间隔这么久再重试
+	refreshRetryInterval	= time.Second
+)
+
+//registerAddr 可以是逗号分隔的多个注册中心地址，当前的注册中心出错时切换到下一个
 func NewGeeRegistryDiscovery(registerAddr string, timeout time.Duration) *GeeRegistryDiscovery {
 	if timeout == 0 {
 		timeout = defaultUpdateTimeout
 	}
+	registries := registry.SplitRegistries(registerAddr)
+	if len(registries) == 0 {
+		registries = []string{registerAddr}
+	}
 	d := &GeeRegistryDiscovery{
 		MultiServersDiscovery: 	NewMultiServerDiscovery(make([]string, 0)),
 		registry:				registerAddr,
+		registries:				registries,
 		timeout:				timeout,
+		httpClient:				&http.Client{Timeout: defaultRefreshTimeout},
 	}
 	return d
 }
 
+//当前使用的注册中心
+func (d *GeeRegistryDiscovery) endpoint() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.registries[d.current]
+}
+
+//切换到下一个注册中心
+func (d *GeeRegistryDiscovery) failover() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.current = (d.current + 1) % len(d.registries)
+}
+
 func (d *GeeRegistryDiscovery) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.servers = servers
+	d.raw, d.instances = nil, nil
 	d.lastUpdate = time.Now()
 	return nil
 }
 
-func (d *GeeRegistryDiscovery) Refresh() error {
+//设置过滤条件，只使用满足条件的服务器
+func (d *GeeRegistryDiscovery) SetFilter(filter InstanceFilter) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	d.filter = filter
+	d.applyLocked()
+}
+
+//设置优先条件，有满足条件的服务器时只使用它们，比如优先同一个可用区
+func (d *GeeRegistryDiscovery) SetPrefer(prefer InstanceFilter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prefer = prefer
+	d.applyLocked()
+}
+
+//返回服务器的元数据，旧版本的注册中心只有地址
+func (d *GeeRegistryDiscovery) Instance(addr string) (registry.ServerItem, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	item, ok := d.instances[addr]
+	return item, ok
+}
+
+//保存注册中心返回的列表
+func (d *GeeRegistryDiscovery) updateInstances(items []registry.ServerItem) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.raw = items
+	d.applyLocked()
+	d.lastUpdate = time.Now()
+	d.lastErr = nil
+}
+
+//最近一次成功刷新的时间，以及之后刷新失败的错误（没有失败时为空）
+func (d *GeeRegistryDiscovery) LastRefresh() (time.Time, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.lastUpdate, d.lastErr
+}
+
+//按过滤和优先条件从原始列表生成服务器列表和权重，需持有锁
+func (d *GeeRegistryDiscovery) applyLocked() {
+	if d.raw == nil {
+		return
+	}
+	var picked, preferred []registry.ServerItem
+	for _, item := range d.raw {
+		if d.filter == nil || d.filter(item) {
+			picked = append(picked, item)
+			if d.prefer != nil && d.prefer(item) {
+				preferred = append(preferred, item)
+			}
+		}
+	}
+	if len(preferred) > 0 {
+		picked = preferred
+	}
+	d.servers = make([]string, 0, len(picked))
+	d.instances = make(map[string]registry.ServerItem, len(picked))
+	d.weights = make(map[string]int, len(picked))
+	for _, item := range picked {
+		d.servers = append(d.servers, item.Addr)
+		d.instances[item.Addr] = item
+		d.weights[item.Addr] = item.Weight
+	}
+}
+
+//列表过期时在后台刷新，刷新期间继续使用旧的列表；
+//只有从来没有拿到过列表时才等待刷新结果。
+func (d *GeeRegistryDiscovery) Refresh() error {
+	d.mu.Lock()
 	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
+		d.mu.Unlock()
 		return nil
 	}
-	log.Println("rpc registry: refresh servers from registry", d.registry)
-	resp, err := http.Get(d.registry)
-	if err != nil {
-		log.Println("rpc registry refresh err:", err)
+	loaded := !d.lastUpdate.IsZero()
+	//刚失败过，不要每次调用都去请求不可用的注册中心
+	if d.refreshing == nil && d.lastErr != nil && d.lastAttempt.Add(refreshRetryInterval).After(time.Now()) {
+		err := d.lastErr
+		d.mu.Unlock()
+		if loaded {
+			return nil
+		}
 		return err
 	}
-	servers := strings.Split(resp.Header.Get("X-Geerpc-Servers"), ",")
-	d.servers = make([]string, 0, len(servers))
-	for _, server := range servers {
-		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
-		}
+	done := d.refreshing
+	if done == nil {
+		done = make(chan struct{})
+		d.refreshing = done
+		go d.refresh(done)
+	}
+	d.mu.Unlock()
+	if loaded {
+		return nil
+	}
+	<-done
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.lastUpdate.IsZero() {
+		return d.lastErr
 	}
-	d.lastUpdate = time.Now()
 	return nil
 }
 
+//从当前的注册中心开始，依次尝试所有注册中心，请求期间不持有锁
+func (d *GeeRegistryDiscovery) refresh(done chan struct{}) {
+	d.mu.RLock()
+	current := d.current
+	d.mu.RUnlock()
+	var items []registry.ServerItem
+	var err error
+	for i := 0; i < len(d.registries); i++ {
+		if items, err = fetchServers(d.httpClient, d.registries[current]); err == nil {
+			break
+		}
+		geerpc.DefaultLogger.Log(geerpc.LevelWarn, "rpc registry: refresh failed", geerpc.F("registry", d.registries[current]), geerpc.F(geerpc.FieldError, err))
+		current = (current + 1) % len(d.registries)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.current = current
+	d.lastAttempt = time.Now()
+	if err == nil {
+		d.raw = items
+		d.applyLocked()
+		d.lastUpdate = d.lastAttempt
+	}
+	d.lastErr = err
+	d.refreshing = nil
+	close(done)
+}
+
+func fetchServers(client *http.Client, addr string) ([]registry.ServerItem, error) {
+	geerpc.DefaultLogger.Log(geerpc.LevelDebug, "rpc registry: refresh servers", geerpc.F("registry", addr))
+	req, err := http.NewRequest("GET", addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	items, _, err := readServerList(resp)
+	return items, err
+}
+
+//解析注册中心的回复，返回服务器列表和版本号（没有时为0）。
+//JSON格式带有完整的元数据，旧版本的注册中心只有 X-Geerpc-Servers 头。
+func readServerList(resp *http.Response) ([]registry.ServerItem, uint64, error) {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var list registry.ServerList
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			return nil, 0, err
+		}
+		if list.Servers == nil {
+			list.Servers = []registry.ServerItem{}
+		}
+		return list.Servers, list.Index, nil
+	}
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Geerpc-Index"), 10, 64)
+	servers := registry.SplitRegistries(resp.Header.Get("X-Geerpc-Servers"))
+	items := make([]registry.ServerItem, 0, len(servers))
+	for _, addr := range servers {
+		items = append(items, registry.ServerItem{Addr: addr})
+	}
+	return items, index, nil
+}
+
 func (d *GeeRegistryDiscovery) Get(mode SelectMode) (string, error) {
 	if err := d.Refresh(); err != nil {
 		return "", err
@@ -72,3 +308,78 @@ func (d *GeeRegistryDiscovery) GetAll() ([]string, error) {
 	}
 	return d.MultiServersDiscovery.GetAll()
 }
+
+//给注册中心地址加上查询参数，空值的参数会被忽略，多个地址时每个都加上
+func registryURL(registryAddr string, params map[string]string) string {
+	registries := registry.SplitRegistries(registryAddr)
+	for i, addr := range registries {
+		u, err := url.Parse(addr)
+		if err != nil {
+			continue
+		}
+		q := u.Query()
+		for k, v := range params {
+			if v != "" {
+				q.Set(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+		registries[i] = u.String()
+	}
+	return strings.Join(registries, ",")
+}
+
+//按服务名区分的注册中心服务发现，每个服务各自向注册中心查询
+//提供该服务的服务器。version 不为空时只返回该版本的服务器。
+type GeeRegistryServiceDiscovery struct {
+	*GeeRegistryDiscovery
+	version		string
+	svcMu		sync.Mutex
+	services	map[string]*GeeRegistryDiscovery
+}
+
+var _ ServiceDiscovery = (*GeeRegistryServiceDiscovery)(nil)
+
+func NewGeeRegistryServiceDiscovery(registerAddr, version string, timeout time.Duration) *GeeRegistryServiceDiscovery {
+	return &GeeRegistryServiceDiscovery{
+		GeeRegistryDiscovery:	NewGeeRegistryDiscovery(registryURL(registerAddr, map[string]string{"version": version}), timeout),
+		version:				version,
+		services:				make(map[string]*GeeRegistryDiscovery),
+	}
+}
+
+//返回只包含提供 name 服务的服务器的 Discovery
+func (d *GeeRegistryServiceDiscovery) Service(name string) Discovery {
+	d.svcMu.Lock()
+	defer d.svcMu.Unlock()
+	sd, ok := d.services[name]
+	if !ok {
+		params := map[string]string{"service": name, "version": d.version}
+		sd = NewGeeRegistryDiscovery(registryURL(d.registry, params), d.timeout)
+		d.mu.RLock()
+		sd.filter, sd.prefer = d.filter, d.prefer
+		d.mu.RUnlock()
+		d.services[name] = sd
+	}
+	return sd
+}
+
+//同时设置所有服务的过滤条件
+func (d *GeeRegistryServiceDiscovery) SetFilter(filter InstanceFilter) {
+	d.svcMu.Lock()
+	defer d.svcMu.Unlock()
+	d.GeeRegistryDiscovery.SetFilter(filter)
+	for _, sd := range d.services {
+		sd.SetFilter(filter)
+	}
+}
+
+//同时设置所有服务的优先条件
+func (d *GeeRegistryServiceDiscovery) SetPrefer(prefer InstanceFilter) {
+	d.svcMu.Lock()
+	defer d.svcMu.Unlock()
+	d.GeeRegistryDiscovery.SetPrefer(prefer)
+	for _, sd := range d.services {
+		sd.SetPrefer(prefer)
+	}
+}
@@ -0,0 +1,195 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"geerpc/registry"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 等待 cond 成立，超时失败
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatal("condition not met in time")
+}
+
+func TestGeeRegistryDiscovery_Failover(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	registry.Heartbeat(ts.URL, "tcp@a", time.Hour)
+
+	//第一个注册中心不可用，切换到第二个
+	d := NewGeeRegistryDiscovery("http://127.0.0.1:1,"+ts.URL, 0)
+	if servers, err := d.GetAll(); err != nil || len(servers) != 1 {
+		t.Fatalf("expect failover to the second registry, got %v %v", servers, err)
+	}
+	if d.endpoint() != ts.URL {
+		t.Fatalf("expect the second registry to be remembered, got %s", d.endpoint())
+	}
+}
+
+func TestGeeRegistryWatchDiscovery(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+
+	//拉取间隔很长，只能依靠watch及时发现新服务
+	d := NewGeeRegistryWatchDiscovery(ts.URL, time.Hour)
+	defer func() { _ = d.Close() }()
+	registry.Heartbeat(ts.URL, "tcp@a", time.Hour)
+	waitFor(t, func() bool {
+		servers, _ := d.GetAll()
+		return len(servers) == 1 && servers[0] == "tcp@a"
+	})
+	registry.Heartbeat(ts.URL, "tcp@b", time.Hour)
+	waitFor(t, func() bool {
+		servers, _ := d.GetAll()
+		return len(servers) == 2
+	})
+}
+
+func TestGeeRegistryServiceDiscovery(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	var foo Foo
+	var bar Bar
+	fooServer, fooAddr := startServerWith(t, &foo)
+	barServer, barAddr := startServerWith(t, &bar)
+	registry.HeartbeatServer(ts.URL, fooServer, registry.ServerItem{Addr: fooAddr}, time.Hour)
+	registry.HeartbeatServer(ts.URL, barServer, registry.ServerItem{Addr: barAddr, Version: "v2"}, time.Hour)
+
+	d := NewGeeRegistryServiceDiscovery(ts.URL, "", 0)
+	if servers, _ := d.Service("Foo").GetAll(); len(servers) != 1 || servers[0] != fooAddr {
+		t.Fatalf("expect only %s for Foo, got %v", fooAddr, servers)
+	}
+	if servers, _ := d.GetAll(); len(servers) != 2 {
+		t.Fatalf("expect 2 servers in total, got %v", servers)
+	}
+	if servers, _ := NewGeeRegistryServiceDiscovery(ts.URL, "v2", 0).Service("Foo").GetAll(); len(servers) != 0 {
+		t.Fatalf("expect no v2 server for Foo, got %v", servers)
+	}
+
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 4; i++ {
+		var reply int
+		if err := xc.Call(context.Background(), "Bar.Double", 2, &reply); err != nil || reply != 4 {
+			t.Fatalf("expect Bar.Double routed to %s: %v", barAddr, err)
+		}
+		if err := xc.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("expect Foo.Sum routed to %s: %v", fooAddr, err)
+		}
+	}
+	var reply int
+	if err := xc.Broadcast(context.Background(), "Foo.Sum", Args{1, 2}, &reply); err != nil {
+		t.Fatalf("expect broadcast only to Foo servers: %v", err)
+	}
+}
+
+func TestGeeRegistryDiscovery_Metadata(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	var foo Foo
+	server := geerpc.NewServer()
+	_ = server.Register(&foo)
+	for _, item := range []registry.ServerItem{
+		{Addr: "tcp@a1", Zone: "a", Version: "v1"},
+		{Addr: "tcp@a2", Zone: "a", Version: "v2", Weight: 100},
+		{Addr: "tcp@b1", Zone: "b", Version: "v2"},
+	} {
+		registry.HeartbeatServer(ts.URL, server, item, time.Hour)
+	}
+
+	d := NewGeeRegistryDiscovery(ts.URL, 0)
+	if item, _ := d.Instance("tcp@a2"); item.Addr != "" {
+		t.Fatal("expect no instance before refresh")
+	}
+	if servers, _ := d.GetAll(); len(servers) != 3 {
+		t.Fatalf("expect 3 servers, got %v", servers)
+	}
+	if item, ok := d.Instance("tcp@a2"); !ok || item.Weight != 100 || len(item.Services) != 1 {
+		t.Fatalf("unexpected instance: %+v", item)
+	}
+
+	d.SetFilter(VersionFilter("v2"))
+	if servers, _ := d.GetAll(); len(servers) != 2 {
+		t.Fatalf("expect 2 v2 servers, got %v", servers)
+	}
+	d.SetPrefer(ZoneFilter("b"))
+	if servers, _ := d.GetAll(); len(servers) != 1 || servers[0] != "tcp@b1" {
+		t.Fatalf("expect zone b preferred, got %v", servers)
+	}
+	d.SetPrefer(ZoneFilter("c"))
+	if servers, _ := d.GetAll(); len(servers) != 2 {
+		t.Fatalf("expect fallback to all v2 servers, got %v", servers)
+	}
+
+	//权重 100 对 1，几乎总是选中 tcp@a2
+	hits := 0
+	for i := 0; i < 100; i++ {
+		if s, _ := d.Get(WeightedRandomSelect); s == "tcp@a2" {
+			hits++
+		}
+	}
+	if hits < 80 {
+		t.Fatalf("expect weighted select to favour tcp@a2, got %d/100", hits)
+	}
+}
+
+func TestGeeRegistryDiscovery_StaleRefresh(t *testing.T) {
+	reg := registry.New(0)
+	var slow int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&slow) == 1 {
+			<-release
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer ts.Close()
+	registry.Heartbeat(ts.URL, "tcp@a", time.Hour)
+
+	d := NewGeeRegistryDiscovery(ts.URL, time.Millisecond*50)
+	if servers, err := d.GetAll(); err != nil || len(servers) != 1 {
+		t.Fatalf("unexpected servers: %v %v", servers, err)
+	}
+	first, _ := d.LastRefresh()
+
+	//注册中心变慢，过期后仍然立即返回旧的列表
+	atomic.StoreInt32(&slow, 1)
+	time.Sleep(time.Millisecond * 60)
+	start := time.Now()
+	if servers, err := d.GetAll(); err != nil || len(servers) != 1 {
+		t.Fatalf("expect the stale list, got %v %v", servers, err)
+	}
+	if time.Since(start) > time.Millisecond*20 {
+		t.Fatal("expect Get not to wait for the registry")
+	}
+	atomic.StoreInt32(&slow, 0)
+	close(release)
+	waitFor(t, func() bool {
+		last, err := d.LastRefresh()
+		return err == nil && last.After(first)
+	})
+
+	//注册中心不可用，记录错误，继续使用旧的列表
+	ts.Close()
+	time.Sleep(time.Millisecond * 60)
+	_, _ = d.GetAll()
+	waitFor(t, func() bool {
+		_, err := d.LastRefresh()
+		return err != nil
+	})
+	if servers, err := d.GetAll(); err != nil || len(servers) != 1 {
+		t.Fatalf("expect the stale list, got %v %v", servers, err)
+	}
+}
@@ -0,0 +1,144 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"geerpc/registry"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//通过注册中心的watch接口（长轮询）订阅服务列表，列表一变化就更新。
+//watch断开期间退回到 GeeRegistryDiscovery 的定时拉取。
+type GeeRegistryWatchDiscovery struct {
+	*GeeRegistryDiscovery
+	wait		time.Duration		//每次watch请求在注册中心挂起的时间
+	httpClient	*http.Client
+	cancel		context.CancelFunc
+	done		chan struct{}
+
+	watchMu		sync.Mutex
+	watching	bool				//watch是否正常，正常时不需要定时拉取
+}
+
+const defaultWatchWait = time.Second * 30
+
+func NewGeeRegistryWatchDiscovery(registerAddr string, timeout time.Duration) *GeeRegistryWatchDiscovery {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &GeeRegistryWatchDiscovery{
+		GeeRegistryDiscovery:	NewGeeRegistryDiscovery(registerAddr, timeout),
+		wait:					defaultWatchWait,
+		//比注册中心挂起的时间稍长，避免把正常的长轮询当成超时
+		httpClient:				&http.Client{Timeout: defaultWatchWait + time.Second*10},
+		cancel:					cancel,
+		done:					make(chan struct{}),
+	}
+	go d.watch(ctx)
+	return d
+}
+
+//停止watch
+func (d *GeeRegistryWatchDiscovery) Close() error {
+	d.cancel()
+	<-d.done
+	return nil
+}
+
+func (d *GeeRegistryWatchDiscovery) setWatching(ok bool) {
+	d.watchMu.Lock()
+	defer d.watchMu.Unlock()
+	d.watching = ok
+}
+
+//watch正常时服务列表已经是最新的，否则按原来的方式定时拉取
+func (d *GeeRegistryWatchDiscovery) Refresh() error {
+	d.watchMu.Lock()
+	watching := d.watching
+	d.watchMu.Unlock()
+	if watching {
+		return nil
+	}
+	return d.GeeRegistryDiscovery.Refresh()
+}
+
+func (d *GeeRegistryWatchDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+func (d *GeeRegistryWatchDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+//循环发起watch请求，出错后退避重试
+func (d *GeeRegistryWatchDiscovery) watch(ctx context.Context) {
+	defer close(d.done)
+	var index uint64
+	backoff := time.Second
+	for {
+		items, next, err := d.watchOnce(ctx, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			geerpc.DefaultLogger.Log(geerpc.LevelWarn, "rpc registry: watch failed", geerpc.F(geerpc.FieldError, err))
+			d.setWatching(false)
+			//换一个注册中心，各个注册中心的版本号互不相关，要从头开始
+			d.failover()
+			index = 0
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			if backoff < d.timeout {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+		if next != index {
+			d.updateInstances(items)
+			index = next
+		}
+		d.setWatching(true)
+	}
+}
+
+func (d *GeeRegistryWatchDiscovery) watchOnce(ctx context.Context, index uint64) ([]registry.ServerItem, uint64, error) {
+	u, err := url.Parse(d.endpoint())
+	if err != nil {
+		return nil, 0, err
+	}
+	q := u.Query()
+	q.Set("index", strconv.FormatUint(index, 10))
+	q.Set("wait", d.wait.String())
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	items, next, err := readServerList(resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	//不支持watch的注册中心不会返回版本号
+	if next == 0 {
+		return nil, 0, errors.New("registry does not support watch")
+	}
+	return items, next, nil
+}
@@ -0,0 +1,49 @@
+package xclient
+
+import (
+	"context"
+	"geerpc/metrics"
+	"time"
+)
+
+//XClient 按服务器地址统计的指标
+type clientMetrics struct {
+	registry	*metrics.Registry
+	requests	*metrics.CounterVec		//addr, method
+	errors		*metrics.CounterVec		//addr, method, code
+	latency		*metrics.HistogramVec	//addr, method
+}
+
+func newClientMetrics() *clientMetrics {
+	r := metrics.NewRegistry()
+	return &clientMetrics{
+		registry:	r,
+		requests:	r.NewCounterVec("geerpc_client_requests_total", "Calls sent, by server address and method.", "addr", "method"),
+		errors:		r.NewCounterVec("geerpc_client_errors_total", "Failed calls, by server address, method and error code.", "addr", "method", "code"),
+		latency:	r.NewHistogramVec("geerpc_client_request_duration_seconds", "Call latency including dialing.", nil, "addr", "method"),
+	}
+}
+
+//客户端的指标，可以用 http.Handle 挂到任意路径上
+func (xc *XClient) Metrics() *metrics.Registry {
+	return xc.metrics.registry
+}
+
+//记录一次调用，dialErr 表示连接没有建立
+func (m *clientMetrics) observe(ctx context.Context, rpcAddr, serviceMethod string, start time.Time, err error, dialErr bool) {
+	m.requests.With(rpcAddr, serviceMethod).Inc()
+	m.latency.With(rpcAddr, serviceMethod).Observe(time.Since(start).Seconds())
+	if err == nil {
+		return
+	}
+	code := "error"
+	switch {
+	case dialErr:
+		code = "dial"
+	case ctx.Err() == context.DeadlineExceeded:
+		code = "timeout"
+	case ctx.Err() == context.Canceled:
+		code = "canceled"
+	}
+	m.errors.With(rpcAddr, serviceMethod, code).Inc()
+}
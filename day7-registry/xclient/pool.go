@@ -0,0 +1,267 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	."geerpc"
+	"sync"
+	"time"
+)
+
+//连接池中选择连接的方式
+type PoolSelect int
+
+const (
+	PoolRoundRobin		PoolSelect = iota	//依次使用池中的连接
+	PoolLeastPending							//使用未完成请求最少的连接
+)
+
+//每个地址的连接池配置，零值表示每个地址一个连接、不回收
+type PoolOption struct {
+	Size		int				//每个地址的连接数，<=0 时为1
+	Select		PoolSelect
+	IdleTimeout	time.Duration	//连接池空闲超过该时间就关闭全部连接，0 表示不回收
+	MaxLifetime	time.Duration	//连接使用超过该时间后不再分配新请求，请求处理完后关闭，0 表示不限制
+}
+
+var errPoolClosed = errors.New("rpc xclient: connection pool is closed")
+
+//池中的一个连接
+type pooledConn struct {
+	*Client
+	pool		*connPool
+	created		time.Time
+	inflight	int			//已分配、还没有结束的请求数
+	retired		bool		//已经不再分配新请求，处理完后关闭
+}
+
+//一个地址的连接池。建立连接时不持有锁，不同的连接可以同时建立。
+type connPool struct {
+	addr		string
+	opt			*Option
+	popt		PoolOption
+
+	mu			sync.Mutex
+	slots		[]*pooledConn
+	dialing		[]chan struct{}		//正在建立连接的位置，连接建立后关闭
+	dialErr		[]error
+	waiters		[]int				//选中了该位置、正在等待连接建立的请求数
+	retired		[]*pooledConn
+	next		int
+	lastUsed	time.Time
+	closed		bool
+	done		chan struct{}		//关闭时关闭，取消正在建立的连接
+}
+
+func newConnPool(addr string, opt *Option, popt PoolOption) *connPool {
+	if popt.Size <= 0 {
+		popt.Size = 1
+	}
+	return &connPool{
+		addr:		addr,
+		opt:		opt,
+		popt:		popt,
+		slots:		make([]*pooledConn, popt.Size),
+		dialing:	make([]chan struct{}, popt.Size),
+		dialErr:	make([]error, popt.Size),
+		waiters:	make([]int, popt.Size),
+		lastUsed:	time.Now(),
+		done:		make(chan struct{}),
+	}
+}
+
+//选择一个位置，需持有锁
+func (p *connPool) pickLocked() int {
+	if p.popt.Select == PoolLeastPending {
+		best := -1
+		for i, pc := range p.slots {
+			n := p.waiters[i]
+			if pc != nil {
+				n = pc.inflight
+			}
+			if best < 0 || n < best {
+				best = n
+				p.next = i
+			}
+		}
+		return p.next
+	}
+	i := p.next
+	p.next = (p.next + 1) % len(p.slots)
+	return i
+}
+
+//连接不可用或者超过最长使用时间时从池中移出，需持有锁
+func (p *connPool) checkLocked(i int, now time.Time) {
+	pc := p.slots[i]
+	if pc == nil {
+		return
+	}
+	expired := p.popt.MaxLifetime > 0 && pc.created.Add(p.popt.MaxLifetime).Before(now)
+	if pc.IsAvailable() && !expired {
+		return
+	}
+	p.slots[i] = nil
+	pc.retired = true
+	if pc.inflight == 0 {
+		_ = pc.Close()
+	} else {
+		p.retired = append(p.retired, pc)
+	}
+}
+
+//取出一个连接，用完后要调用 release。ctx 取消时放弃等待和建立连接
+func (p *connPool) get(ctx context.Context) (*pooledConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errPoolClosed
+	}
+	i := p.pickLocked()
+	p.waiters[i]++
+	defer func() {
+		p.waiters[i]--
+	}()
+	for {
+		now := time.Now()
+		p.checkLocked(i, now)
+		if pc := p.slots[i]; pc != nil {
+			pc.inflight++
+			p.lastUsed = now
+			return pc, nil
+		}
+		//其他请求正在建立这个位置的连接，等待它的结果
+		if ch := p.dialing[i]; ch != nil {
+			p.mu.Unlock()
+			select {
+			case <-ch:
+			case <-ctx.Done():
+				p.mu.Lock()
+				return nil, ctx.Err()
+			}
+			p.mu.Lock()
+			if p.closed {
+				return nil, errPoolClosed
+			}
+			if p.slots[i] == nil && p.dialErr[i] != nil {
+				return nil, p.dialErr[i]
+			}
+			continue
+		}
+		ch := make(chan struct{})
+		p.dialing[i] = ch
+		p.mu.Unlock()
+		client, err := p.dial(ctx)
+		p.mu.Lock()
+		p.dialing[i], p.dialErr[i] = nil, err
+		//因为自己的 ctx 取消而失败时，等待的请求自己重新建立连接
+		if err != nil && ctx.Err() != nil {
+			p.dialErr[i] = nil
+		}
+		close(ch)
+		//建立连接期间连接池被关闭，不能放进池里
+		if p.closed {
+			if client != nil {
+				_ = client.Close()
+			}
+			return nil, errPoolClosed
+		}
+		if err != nil {
+			return nil, err
+		}
+		//新建的连接直接分配，不再检查是否到期，否则很短的 MaxLifetime 会一直重新建立连接
+		pc := &pooledConn{Client: client, pool: p, created: time.Now(), inflight: 1}
+		p.slots[i] = pc
+		p.lastUsed = pc.created
+		return pc, nil
+	}
+}
+
+//请求结束，移出池的连接没有请求时关闭
+func (p *connPool) release(pc *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc.inflight--
+	p.lastUsed = time.Now()
+	if pc.retired && pc.inflight == 0 {
+		_ = pc.Close()
+		for i, r := range p.retired {
+			if r == pc {
+				p.retired = append(p.retired[:i], p.retired[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+//定期检查：移出到期的连接；空闲超时返回 true，此时连接池已经关闭
+func (p *connPool) sweep(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	busy := false
+	for i := range p.slots {
+		p.checkLocked(i, now)
+		if p.slots[i] != nil && p.slots[i].inflight > 0 || p.dialing[i] != nil {
+			busy = true
+		}
+	}
+	//checkLocked 可能刚把还有请求的连接移出
+	if len(p.retired) > 0 {
+		busy = true
+	}
+	if busy || p.popt.IdleTimeout <= 0 || p.lastUsed.Add(p.popt.IdleTimeout).After(now) {
+		return false
+	}
+	p.closeLocked()
+	return true
+}
+
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeLocked()
+}
+
+//建立连接，ctx 取消或者连接池关闭时放弃
+func (p *connPool) dial(ctx context.Context) (*Client, error) {
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-p.done:
+			cancel()
+		case <-dialCtx.Done():
+		}
+	}()
+	return XDialContext(dialCtx, p.addr, p.opt)
+}
+
+func (p *connPool) closeLocked() {
+	if !p.closed {
+		close(p.done)
+	}
+	p.closed = true
+	for i, pc := range p.slots {
+		if pc != nil {
+			_ = pc.Close()
+			p.slots[i] = nil
+		}
+	}
+	for _, pc := range p.retired {
+		_ = pc.Close()
+	}
+	p.retired = nil
+}
+
+//池中的连接数，包括已经移出、还在处理请求的连接
+func (p *connPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.retired)
+	for _, pc := range p.slots {
+		if pc != nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,137 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 加锁读取，避免和定期清理竞争
+func poolOf(xc *XClient, addr string) *connPool {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.pools[addr]
+}
+
+func slotClient(p *connPool, i int) *geerpc.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.slots[i] == nil {
+		return nil
+	}
+	return p.slots[i].Client
+}
+
+func TestXClient_Pool(t *testing.T) {
+	addr := startServer(t)
+	opt := &geerpc.Option{ConnectTimeout: time.Second}
+
+	t.Run("least pending", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		xc.SetPool(PoolOption{Size: 3, Select: PoolLeastPending})
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var reply int
+				if err := xc.Call(context.Background(), "Foo.Sleep", Args{100, 1}, &reply); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := poolOf(xc, addr).size(); n != 3 {
+			t.Fatalf("expect 3 connections, got %d", n)
+		}
+	})
+	t.Run("round robin", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		xc.SetPool(PoolOption{Size: 2})
+		var reply int
+		for i := 0; i < 4; i++ {
+			if err := xc.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := poolOf(xc, addr).size(); n != 2 {
+			t.Fatalf("expect 2 connections, got %d", n)
+		}
+	})
+	t.Run("max lifetime", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		xc.SetPool(PoolOption{MaxLifetime: time.Millisecond * 50})
+		var reply int
+		_ = xc.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply)
+		old := slotClient(poolOf(xc, addr), 0)
+		time.Sleep(time.Millisecond * 80)
+		if err := xc.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if slotClient(poolOf(xc, addr), 0) == old || old.IsAvailable() {
+			t.Fatal("expect the expired connection to be replaced and closed")
+		}
+	})
+	t.Run("idle", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		xc.SetPool(PoolOption{IdleTimeout: time.Millisecond * 50})
+		var reply int
+		_ = xc.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply)
+		waitFor(t, func() bool {
+			xc.mu.Lock()
+			defer xc.mu.Unlock()
+			return len(xc.pools) == 0
+		})
+		if err := xc.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("expect a new connection after eviction, got %v", err)
+		}
+	})
+	t.Run("tiny timeouts", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		xc.SetPool(PoolOption{IdleTimeout: time.Nanosecond, MaxLifetime: time.Nanosecond})
+		for i := 0; i < 3; i++ {
+			var reply int
+			if err := xc.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply); err != nil || reply != 3 {
+				t.Fatalf("call failed: %v", err)
+			}
+			time.Sleep(time.Millisecond * 5)
+		}
+	})
+}
+
+func TestXClient_CloseWhileDialing(t *testing.T) {
+	addr := startBlackhole(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, &geerpc.Option{ConnectTimeout: time.Second * 5})
+	errc := make(chan error, 1)
+	go func() {
+		var reply int
+		errc <- xc.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply)
+	}()
+	waitFor(t, func() bool { return poolOf(xc, addr) != nil })
+	_ = xc.Close()
+
+	// 关闭时取消正在建立的连接
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("expect an error after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect Close to cancel the dial")
+	}
+	// 关闭之后不再新建连接池
+	var reply int
+	if err := xc.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply); err != geerpc.ErrShutdown {
+		t.Fatalf("expect ErrShutdown after Close, got %v", err)
+	}
+	if poolOf(xc, addr) != nil {
+		t.Fatal("expect no pool after Close")
+	}
+}
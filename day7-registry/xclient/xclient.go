@@ -2,84 +2,196 @@ package xclient
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	."geerpc"
+	"geerpc/tracing"
 	"io"
+	"math/rand"
 	"reflect"
+	"strings"
 	"sync"
-
+	"time"
 )
 
 type XClient struct {
 	d		Discovery
 	mode	SelectMode
 	opt		*Option
-	mu		sync.Mutex
-	clients	map[string]*Client
+	mu		sync.Mutex			//只保护 pools 和 closed，建立连接时不持有
+	pool	PoolOption
+	pools	map[string]*connPool
+	closed	bool				//Close 之后不再新建连接池
+	stop	chan struct{}		//停止定期清理连接池
+	metrics	*clientMetrics
 }
 
 var _ io.Closer = (*XClient)(nil)
+var _ Caller = (*XClient)(nil)
 //构造函数
 func NewXClient(d Discovery, mode SelectMode, opt *Option) *XClient {
 	return &XClient{
 		d: d, 
 		mode: mode, 
 		opt: opt, 
-		clients: make(map[string]*Client),
+		pools: make(map[string]*connPool),
+		metrics: newClientMetrics(),
+	}
+}
+
+//设置客户端的日志，连接池中的连接也使用这个日志，需在第一次调用之前设置
+func (xc *XClient) SetLogger(l Logger) {
+	opt := *DefaultOption
+	if xc.opt != nil {
+		opt = *xc.opt
+	}
+	opt.Logger = l
+	xc.opt = &opt
+}
+
+func (xc *XClient) log() Logger {
+	if xc.opt == nil || xc.opt.Logger == nil {
+		return DefaultLogger
+	}
+	return xc.opt.Logger
+}
+
+//设置每个地址的连接池，需在第一次调用之前设置
+func (xc *XClient) SetPool(pool PoolOption) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.pool = pool
+	if xc.stop != nil || (pool.IdleTimeout <= 0 && pool.MaxLifetime <= 0) {
+		return
+	}
+	interval := pool.IdleTimeout
+	if interval <= 0 || (pool.MaxLifetime > 0 && pool.MaxLifetime < interval) {
+		interval = pool.MaxLifetime
+	}
+	//太小的间隔会让 NewTicker panic
+	if interval /= 2; interval < minSweepInterval {
+		interval = minSweepInterval
+	}
+	xc.stop = make(chan struct{})
+	go xc.sweep(interval, xc.stop)
+}
+
+//清理连接池的最小间隔
+const minSweepInterval = time.Millisecond
+
+//定期回收空闲的连接池、替换到期的连接
+func (xc *XClient) sweep(interval time.Duration, stop chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			xc.mu.Lock()
+			for addr, p := range xc.pools {
+				if p.sweep(now) {
+					delete(xc.pools, addr)
+				}
+			}
+			xc.mu.Unlock()
+		}
 	}
 }
+
 //关闭连接
 func (xc *XClient) Close() error {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-	for key, client := range xc.clients {
-		_ = client.Close()
-		delete(xc.clients, key)
+	xc.closed = true
+	if xc.stop != nil {
+		close(xc.stop)
+		xc.stop = nil
+	}
+	for key, p := range xc.pools {
+		p.close()
+		delete(xc.pools, key)
 	}
 	return nil
 }
 
-func (xc *XClient) dial(rpcAddr string) (*Client, error) {
-	xc.mu.Lock()
-	defer xc.mu.Unlock()
-	client, ok := xc.clients[rpcAddr]
-	//如果已经不可用，就删除
-	if ok && !client.IsAvailable() {
-		_ = client.Close()
-		delete(xc.clients, rpcAddr)
-		client = nil
-	}
-	//如果不存在或被删除，就尝试建立一个连接
-	if client == nil {
-		var err error 
-		client, err = XDial(rpcAddr, xc.opt)
-		if err != nil {
-			return nil, err
+//从 rpcAddr 的连接池中取一个连接，连接池不存在就新建，ctx 取消时放弃建立连接
+func (xc *XClient) dial(ctx context.Context, rpcAddr string) (*pooledConn, error) {
+	for {
+		xc.mu.Lock()
+		if xc.closed {
+			xc.mu.Unlock()
+			return nil, ErrShutdown
+		}
+		p, ok := xc.pools[rpcAddr]
+		if !ok {
+			p = newConnPool(rpcAddr, xc.opt, xc.pool)
+			xc.pools[rpcAddr] = p
 		}
-		xc.clients[rpcAddr] = client
+		xc.mu.Unlock()
+		pc, err := p.get(ctx)
+		//连接池刚好被回收，重新取
+		if err == errPoolClosed {
+			xc.mu.Lock()
+			if xc.pools[rpcAddr] == p {
+				delete(xc.pools, rpcAddr)
+			}
+			xc.mu.Unlock()
+			continue
+		}
+		return pc, err
 	}
-	return client, nil
 }
 
 //先尝试远程addr，然后再调用
 func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	client, err := xc.dial(rpcAddr)
+	start := time.Now()
+	pc, err := xc.dial(ctx, rpcAddr)
 	if err != nil {
+		xc.log().Log(LevelWarn, "rpc xclient: dial failed", F(FieldPeer, rpcAddr), F(FieldError, err))
+		xc.metrics.observe(ctx, rpcAddr, serviceMethod, start, err, true)
 		return err
 	}
-	return client.Call(ctx, serviceMethod, args, reply)
+	defer pc.pool.release(pc)
+	err = pc.Call(ctx, serviceMethod, args, reply)
+	xc.metrics.observe(ctx, rpcAddr, serviceMethod, start, err, false)
+	return err
 } 
+//服务发现支持按服务区分时，返回该服务对应的 Discovery
+func (xc *XClient) discovery(serviceMethod string) Discovery {
+	if sd, ok := xc.d.(ServiceDiscovery); ok {
+		if dot := strings.LastIndex(serviceMethod, "."); dot > 0 {
+			return sd.Service(serviceMethod[:dot])
+		}
+	}
+	return xc.d
+}
+
 //对外的接口，通过get获取远程addr，获取远程服务器的addr后调用之
-func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
+//Option.Tracer 不为空时，选择服务器和调用都在一个 span 里，Client.Call 的 span 是它的子 span
+func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) (err error) {
+	var span tracing.Span
+	if xc.opt != nil && xc.opt.Tracer != nil {
+		ctx, span = xc.opt.Tracer.Start(ctx, serviceMethod, tracing.SpanKindInternal)
+		span.SetAttribute(tracing.AttrMethod, serviceMethod)
+		defer func() {
+			span.SetError(err)
+			span.End()
+		}()
+	}
+	rpcAddr, err := xc.discovery(serviceMethod).Get(xc.mode)
 	if err != nil {
 		return err
 	}
+	if span != nil {
+		span.SetAttribute(tracing.AttrPeer, rpcAddr)
+	}
 	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
 }
 
 //广播，获取所有的保存的服务，然后返回可能出现的错误。
 func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	servers, err := xc.d.GetAll()
+	servers, err := xc.discovery(serviceMethod).GetAll()
 	if err != nil {
 		return err
 	}
@@ -88,6 +200,7 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var e error
 	replyDone := reply == nil
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func (rpcAddr string) {
@@ -113,4 +226,125 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	return e
 }
 
-//
+//<-----------------------聚合调用------------------------->
+
+//单个服务器的调用结果，Reply 是按 reply 类型新建的实例
+type GatherResult struct {
+	Reply	interface{}
+	Error	error
+}
+
+//按地址索引的结果集
+type GatherResults map[string]*GatherResult
+
+//聚合策略，Quorum 是需要成功的服务器个数，<=0 表示全部
+type GatherPolicy struct {
+	Quorum	int
+}
+
+var (
+	GatherAll			= GatherPolicy{Quorum: 0}	//等待全部服务器成功
+	GatherFirstSuccess	= GatherPolicy{Quorum: 1}	//任意一个成功即返回
+)
+
+//至少 n 个服务器成功
+func GatherQuorum(n int) GatherPolicy {
+	return GatherPolicy{Quorum: n}
+}
+
+//对所有服务器发起调用，收集每个地址的回复或错误。
+//成功数满足策略后立即返回，不等待其余的调用，它们的错误为 errGatherCanceled；
+//否则等待全部返回，无法满足时返回错误，但结果集仍然返回。
+//reply 不为 nil 时，第一个成功的回复会写入 reply。
+func (xc *XClient) Gather(ctx context.Context, serviceMethod string, args, reply interface{}, policy GatherPolicy) (GatherResults, error) {
+	servers, err := xc.discovery(serviceMethod).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return xc.gather(ctx, servers, serviceMethod, args, reply, policy)
+}
+
+func (xc *XClient) gather(ctx context.Context, servers []string, serviceMethod string, args, reply interface{}, policy GatherPolicy) (GatherResults, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("rpc xclient: no available servers")
+	}
+	need := policy.Quorum
+	if need <= 0 || need > len(servers) {
+		need = len(servers)
+	}
+	//返回时取消还没有结束的调用，它们在后台结束，结果被丢弃
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type gatherDone struct {
+		addr	string
+		reply	interface{}
+		err		error
+	}
+	ch := make(chan gatherDone, len(servers))
+	for _, rpcAddr := range servers {
+		go func(rpcAddr string) {
+			var clonedReply interface{}
+			if reply != nil {
+				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			ch <- gatherDone{addr: rpcAddr, reply: clonedReply, err: err}
+		}(rpcAddr)
+	}
+	results := make(GatherResults, len(servers))
+	succeeded := 0
+	//满足策略后立即返回，否则等待全部返回
+	for received := 0; received < len(servers) && succeeded < need; received++ {
+		done := <-ch
+		result := &GatherResult{Error: done.err}
+		if done.err == nil {
+			result.Reply = done.reply
+			if succeeded == 0 && reply != nil {
+				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(done.reply).Elem())
+			}
+			succeeded++
+		}
+		results[done.addr] = result
+	}
+	for _, rpcAddr := range servers {
+		if results[rpcAddr] == nil {
+			results[rpcAddr] = &GatherResult{Error: errGatherCanceled}
+		}
+	}
+	if succeeded < need {
+		return results, fmt.Errorf("rpc xclient: %s got %d successful replies, need %d", serviceMethod, succeeded, need)
+	}
+	return results, nil
+}
+
+//Gather 返回时还没有结束的调用的错误
+var errGatherCanceled = errors.New("rpc xclient: call canceled, gather already finished")
+
+//<-----------------------fork调用------------------------->
+
+//把同一个请求发给 k 个服务器（k<=0 表示全部），第一个成功的回复写入 reply 后立即返回，
+//其余调用通过取消 ctx 结束。全部失败时按服务器的顺序返回所有的错误。
+func (xc *XClient) Fork(ctx context.Context, k int, serviceMethod string, args, reply interface{}) error {
+	servers, err := xc.discovery(serviceMethod).GetAll()
+	if err != nil {
+		return err
+	}
+	//随机挑选 k 个，避免每次都压在列表前面的服务器上
+	if k > 0 && k < len(servers) {
+		picked := make([]string, 0, k)
+		for _, i := range rand.Perm(len(servers))[:k] {
+			picked = append(picked, servers[i])
+		}
+		servers = picked
+	}
+	results, err := xc.gather(ctx, servers, serviceMethod, args, reply, GatherFirstSuccess)
+	if err == nil {
+		return nil
+	}
+	errs := make([]string, 0, len(servers))
+	for _, rpcAddr := range servers {
+		errs = append(errs, rpcAddr+": "+results[rpcAddr].Error.Error())
+	}
+	return fmt.Errorf("rpc xclient: fork %s failed on %d servers: %s", serviceMethod, len(servers), strings.Join(errs, "; "))
+}
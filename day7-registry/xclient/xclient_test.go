@@ -0,0 +1,243 @@
+package xclient
+
+import (
+	"bytes"
+	"context"
+	"geerpc"
+	"geerpc/tracing"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f Foo) Sleep(args Args, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(args.Num1))
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+type Bar int
+
+func (b Bar) Double(n int, reply *int) error {
+	*reply = n * 2
+	return nil
+}
+
+//启动一个注册了 Foo 的服务器，返回 XDial 格式的地址
+func startServer(t *testing.T) string {
+	var foo Foo
+	_, addr := startServerWith(t, &foo)
+	return addr
+}
+
+//启动一个注册了 rcvrs 的服务器
+func startServerWith(t *testing.T, rcvrs ...interface{}) (*geerpc.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := geerpc.NewServer()
+	for _, rcvr := range rcvrs {
+		_ = server.Register(rcvr)
+	}
+	go server.Accept(l)
+	t.Cleanup(func() { _ = l.Close() })
+	return server, "tcp@" + l.Addr().String()
+}
+
+//一个拒绝连接的地址
+const deadAddr = "tcp@127.0.0.1:1"
+
+func TestXClient_Gather(t *testing.T) {
+	addr1, addr2 := startServer(t), startServer(t)
+	opt := &geerpc.Option{ConnectTimeout: time.Second}
+
+	t.Run("all", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{addr1, addr2}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		var reply int
+		results, err := xc.Gather(context.Background(), "Foo.Sum", Args{1, 2}, &reply, GatherAll)
+		if err != nil || reply != 3 || len(results) != 2 {
+			t.Fatalf("unexpected gather: %v %d %v", err, reply, results)
+		}
+		for addr, r := range results {
+			if r.Error != nil || *r.Reply.(*int) != 3 {
+				t.Fatalf("unexpected result from %s: %+v", addr, r)
+			}
+		}
+	})
+	t.Run("all with failure", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{addr1, deadAddr}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		var reply int
+		results, err := xc.Gather(context.Background(), "Foo.Sum", Args{1, 2}, &reply, GatherAll)
+		if err == nil || len(results) != 2 {
+			t.Fatalf("expect an error and 2 results, got %v %v", err, results)
+		}
+		if results[addr1].Error != nil || results[deadAddr].Error == nil {
+			t.Fatalf("unexpected results: %+v %+v", results[addr1], results[deadAddr])
+		}
+	})
+	t.Run("quorum", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{addr1, addr2, deadAddr}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		var reply int
+		_, err := xc.Gather(context.Background(), "Foo.Sum", Args{1, 2}, &reply, GatherQuorum(2))
+		if err != nil || reply != 3 {
+			t.Fatalf("expect quorum of 2, got %v", err)
+		}
+		_, err = xc.Gather(context.Background(), "Foo.Sum", Args{1, 2}, &reply, GatherQuorum(3))
+		if err == nil {
+			t.Fatal("expect quorum of 3 to fail")
+		}
+	})
+	t.Run("first success", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{deadAddr, addr1, addr2}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		var reply int
+		results, err := xc.Gather(context.Background(), "Foo.Sleep", Args{10, 1}, &reply, GatherFirstSuccess)
+		if err != nil || reply != 11 || len(results) != 3 {
+			t.Fatalf("unexpected gather: %v %d %v", err, reply, results)
+		}
+		if results[deadAddr].Error == nil {
+			t.Fatal("expect an error from the dead server")
+		}
+	})
+}
+
+func TestXClient_Fork(t *testing.T) {
+	addr1, addr2 := startServer(t), startServer(t)
+	opt := &geerpc.Option{ConnectTimeout: time.Second}
+
+	t.Run("first success", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{deadAddr, addr1, addr2}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		var reply int
+		if err := xc.Fork(context.Background(), 0, "Foo.Sum", Args{1, 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("unexpected fork: %v %d", err, reply)
+		}
+	})
+	t.Run("k servers", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{addr1, addr2}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		var reply int
+		if err := xc.Fork(context.Background(), 1, "Foo.Sum", Args{1, 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("unexpected fork: %v %d", err, reply)
+		}
+	})
+	t.Run("all failed", func(t *testing.T) {
+		deadAddr2 := "tcp@127.0.0.1:2"
+		xc := NewXClient(NewMultiServerDiscovery([]string{deadAddr, deadAddr2}), RandomSelect, opt)
+		defer func() { _ = xc.Close() }()
+		var reply int
+		err := xc.Fork(context.Background(), 0, "Foo.Sum", Args{1, 2}, &reply)
+		if err == nil {
+			t.Fatal("expect an error when every server fails")
+		}
+		// 错误按服务器的顺序列出
+		msg := err.Error()
+		if i, j := strings.Index(msg, deadAddr+":"), strings.Index(msg, deadAddr2+":"); i < 0 || j < i {
+			t.Fatalf("expect errors of both servers in order, got %v", err)
+		}
+	})
+	t.Run("blackholed server", func(t *testing.T) {
+		xc := NewXClient(NewMultiServerDiscovery([]string{startBlackhole(t), addr1}), RandomSelect,
+			&geerpc.Option{ConnectTimeout: time.Second * 5})
+		defer func() { _ = xc.Close() }()
+		var reply int
+		start := time.Now()
+		if err := xc.Fork(context.Background(), 0, "Foo.Sum", Args{1, 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("unexpected fork: %v %d", err, reply)
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("expect fork not to wait for the blackholed server, took %s", time.Since(start))
+		}
+	})
+}
+
+// 接受连接但从不响应的地址，HTTP 握手会一直阻塞
+func startBlackhole(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+	return "http@" + l.Addr().String()
+}
+
+func TestXClient_Metrics(t *testing.T) {
+	addr := startServer(t)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr, deadAddr}), RoundRobinSelect, &geerpc.Option{ConnectTimeout: time.Second})
+	defer func() { _ = xc.Close() }()
+	var reply int
+	_ = xc.Broadcast(context.Background(), "Foo.Sum", Args{1, 2}, &reply)
+
+	var buf bytes.Buffer
+	_, _ = xc.Metrics().WriteTo(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		`geerpc_client_requests_total{addr="` + addr + `",method="Foo.Sum"} 1`,
+		`geerpc_client_errors_total{addr="` + deadAddr + `",method="Foo.Sum",code="dial"} 1`,
+		`geerpc_client_request_duration_seconds_count{addr="` + addr + `",method="Foo.Sum"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expect %q in metrics:\n%s", want, out)
+		}
+	}
+}
+
+func TestXClient_Tracing(t *testing.T) {
+	rec := tracing.NewRecorder()
+	server, addr := startServerWith(t, new(Foo))
+	server.SetTracer(rec)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, &geerpc.Option{Tracer: rec})
+	defer func() { _ = xc.Close() }()
+	var reply int
+	if err := xc.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	_ = xc.Call(context.Background(), "Foo.Nope", Args{1, 2}, &reply)
+
+	//服务端的 span 可能晚于客户端结束
+	waitFor(t, func() bool { return len(rec.Spans()) == 5 })
+	byKind := make(map[tracing.SpanKind]tracing.RecordedSpan)
+	for _, s := range rec.Spans() {
+		if s.Attributes[tracing.AttrMethod] == "Foo.Sum" {
+			byKind[s.Kind] = s
+		}
+	}
+	xs, cs, ss := byKind[tracing.SpanKindInternal], byKind[tracing.SpanKindClient], byKind[tracing.SpanKindServer]
+	if cs.Parent != xs.Context.SpanID || ss.Parent != cs.Context.SpanID {
+		t.Fatalf("expect XClient -> Client -> Server spans, got %+v", rec.Spans())
+	}
+	if ss.Context.TraceID != xs.Context.TraceID || xs.Attributes[tracing.AttrPeer] != addr {
+		t.Fatalf("unexpected spans: %+v %+v", xs, ss)
+	}
+	if cs.Attributes[tracing.AttrSeq] != ss.Attributes[tracing.AttrSeq] || ss.Attributes[tracing.AttrPeer] == nil {
+		t.Fatalf("expect seq and peer attributes: %+v %+v", cs.Attributes, ss.Attributes)
+	}
+	for _, s := range rec.Spans() {
+		if s.Attributes[tracing.AttrMethod] == "Foo.Nope" && s.Err == nil {
+			t.Fatalf("expect an error on %s span", s.Kind)
+		}
+	}
+}